@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/eefret/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// Actions recorded in the audit log. The part before the dot groups them,
+// and the log can be filtered by it.
+const (
+	auditUserCreated            = "user.created"
+	auditUserUpgraded           = "user.upgraded"
+	auditLoginSucceeded         = "login.succeeded"
+	auditLoginFailed            = "login.failed"
+	auditLoginThrottled         = "login.throttled"
+	auditPasswordChanged        = "password.changed"
+	auditPasswordResetRequested = "password.reset_requested"
+	auditPasswordReset          = "password.reset"
+	auditEmailChangeRequested   = "email.change_requested"
+	auditEmailVerified          = "email.verified"
+	auditSessionRevoked         = "session.revoked"
+	auditSessionsRevoked        = "session.revoked_all"
+	auditRefreshTokenReused     = "session.token_reused"
+	auditTwoFactorEnabled       = "2fa.enabled"
+	auditTwoFactorDisabled      = "2fa.disabled"
+	auditTokenCreated           = "token.created"
+	auditTokenRevoked           = "token.revoked"
+	auditOAuthClientCreated     = "oauth.client_created"
+	auditOAuthClientDeleted     = "oauth.client_deleted"
+	auditOAuthGranted           = "oauth.granted"
+	auditOAuthDenied            = "oauth.denied"
+	auditOAuthCodeReused        = "oauth.code_reused"
+	auditOAuthRevoked           = "oauth.revoked"
+	auditIdentityLinked         = "identity.linked"
+	auditRoleChanged            = "admin.role_changed"
+	auditUserUnlocked           = "admin.user_unlocked"
+	auditReset                  = "admin.reset"
+	auditLogExported            = "admin.audit_exported"
+)
+
+const (
+	// auditPageSize is the default number of events per page.
+	auditPageSize = 100
+	// auditMaxPageSize bounds pages, and is the batch size of exports.
+	auditMaxPageSize = 1000
+)
+
+// auditEvent is a security relevant action to record.
+type auditEvent struct {
+	// Actor is the user who acted, or uuid.Nil when nobody is logged in,
+	// as with failed logins.
+	Actor  uuid.UUID
+	Action string
+	// TargetType and TargetID name what was acted on, such as a user or a
+	// token.
+	TargetType string
+	TargetID   string
+	Metadata   map[string]any
+}
+
+// audit appends an event to the audit log, along with the client's IP and
+// user agent. A failure to write is logged but does not fail the request.
+func (cfg *apiConfig) audit(r *http.Request, e auditEvent) {
+	if e.Metadata == nil {
+		e.Metadata = map[string]any{}
+	}
+	metadata, err := json.Marshal(e.Metadata)
+	if err != nil {
+		fmt.Println("Error marshalling audit metadata:", err)
+		metadata = []byte("{}")
+	}
+
+	// The event is written even if the client has gone away.
+	err = cfg.DB.CreateAuditEvent(context.WithoutCancel(r.Context()), database.CreateAuditEventParams{
+		ActorID:    uuid.NullUUID{UUID: e.Actor, Valid: e.Actor != uuid.Nil},
+		Action:     e.Action,
+		TargetType: e.TargetType,
+		TargetID:   e.TargetID,
+		Ip:         cfg.clientIP(r),
+		UserAgent:  r.UserAgent(),
+		Metadata:   metadata,
+	})
+	if err != nil {
+		fmt.Println("Error writing audit event:", err)
+	}
+}
+
+// adminActor returns the admin making a request to an /admin endpoint.
+func adminActor(r *http.Request) uuid.UUID {
+	claims := claimsFromContext(r.Context())
+	if claims == nil {
+		return uuid.Nil
+	}
+	id, _ := claims.UserID()
+	return id
+}
+
+type AuditEvent struct {
+	ID         int64           `json:"id"`
+	CreatedAt  time.Time       `json:"created_at"`
+	ActorID    *uuid.UUID      `json:"actor_id"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type,omitempty"`
+	TargetID   string          `json:"target_id,omitempty"`
+	IP         string          `json:"ip"`
+	UserAgent  string          `json:"user_agent"`
+	Metadata   json.RawMessage `json:"metadata"`
+}
+
+func auditEventFromDB(e database.AuditEvent) AuditEvent {
+	event := AuditEvent{
+		ID:         e.ID,
+		CreatedAt:  e.CreatedAt,
+		Action:     e.Action,
+		TargetType: e.TargetType,
+		TargetID:   e.TargetID,
+		IP:         e.Ip,
+		UserAgent:  e.UserAgent,
+		Metadata:   e.Metadata,
+	}
+	if e.ActorID.Valid {
+		event.ActorID = &e.ActorID.UUID
+	}
+	return event
+}
+
+// auditFilter reads the filters of GET /admin/audit from the query string.
+func auditFilter(r *http.Request) (database.ListAuditEventsParams, error) {
+	query := r.URL.Query()
+	params := database.ListAuditEventsParams{MaxRows: auditPageSize}
+
+	if v := query.Get("actor_id"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			return params, errors.New("Invalid actor_id")
+		}
+		params.ActorID = uuid.NullUUID{UUID: id, Valid: true}
+	}
+	if v := query.Get("action"); v != "" {
+		params.Action = sql.NullString{String: v, Valid: true}
+	}
+	if v := query.Get("target_id"); v != "" {
+		params.TargetID = sql.NullString{String: v, Valid: true}
+	}
+	if v := query.Get("ip"); v != "" {
+		params.Ip = sql.NullString{String: v, Valid: true}
+	}
+	if v := query.Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return params, errors.New("since must be an RFC 3339 time")
+		}
+		params.Since = sql.NullTime{Time: t, Valid: true}
+	}
+	if v := query.Get("until"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return params, errors.New("until must be an RFC 3339 time")
+		}
+		params.Until = sql.NullTime{Time: t, Valid: true}
+	}
+	if v := query.Get("before"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return params, errors.New("Invalid before")
+		}
+		params.BeforeID = sql.NullInt64{Int64: id, Valid: true}
+	}
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > auditMaxPageSize {
+			return params, fmt.Errorf("limit must be between 1 and %d", auditMaxPageSize)
+		}
+		params.MaxRows = int32(limit)
+	}
+
+	return params, nil
+}
+
+// handleListAuditEvents returns audit events newest first. With
+// format=csv or format=jsonl every matching event is exported as a file
+// instead of a page.
+func (cfg *apiConfig) handleListAuditEvents(w http.ResponseWriter, r *http.Request) {
+	params, err := auditFilter(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+	case "csv", "jsonl":
+		cfg.exportAuditEvents(w, r, params, format)
+		return
+	default:
+		respondWithError(w, http.StatusBadRequest, "format must be json, csv or jsonl")
+		return
+	}
+
+	events, err := cfg.DB.ListAuditEvents(r.Context(), params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not list audit events")
+		return
+	}
+
+	type AuditEventsResponse struct {
+		Events []AuditEvent `json:"events"`
+		// NextBefore is passed as before to get the next page.
+		NextBefore *int64 `json:"next_before,omitempty"`
+	}
+
+	response := AuditEventsResponse{Events: []AuditEvent{}}
+	for _, e := range events {
+		response.Events = append(response.Events, auditEventFromDB(e))
+	}
+	if len(events) == int(params.MaxRows) {
+		response.NextBefore = &events[len(events)-1].ID
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// exportAuditEvents streams every event matching params in batches, so an
+// export does not have to fit in memory.
+func (cfg *apiConfig) exportAuditEvents(w http.ResponseWriter, r *http.Request, params database.ListAuditEventsParams, format string) {
+	// Exports take the log off the server, so they are logged themselves.
+	cfg.audit(r, auditEvent{
+		Actor:    adminActor(r),
+		Action:   auditLogExported,
+		Metadata: map[string]any{"format": format, "query": r.URL.RawQuery},
+	})
+
+	var write func(AuditEvent) error
+	var flush func() error
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		cw := csv.NewWriter(w)
+		write = func(e AuditEvent) error {
+			actorID := ""
+			if e.ActorID != nil {
+				actorID = e.ActorID.String()
+			}
+			return cw.Write([]string{
+				strconv.FormatInt(e.ID, 10),
+				e.CreatedAt.UTC().Format(time.RFC3339Nano),
+				actorID,
+				e.Action,
+				e.TargetType,
+				csvSafe(e.TargetID),
+				csvSafe(e.IP),
+				csvSafe(e.UserAgent),
+				csvSafe(string(e.Metadata)),
+			})
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+		if err := cw.Write([]string{"id", "created_at", "actor_id", "action", "target_type", "target_id", "ip", "user_agent", "metadata"}); err != nil {
+			return
+		}
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		encoder := json.NewEncoder(w)
+		write = func(e AuditEvent) error {
+			return encoder.Encode(e)
+		}
+		flush = func() error { return nil }
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"audit-events.%s\"", format))
+
+	params.MaxRows = auditMaxPageSize
+	for {
+		events, err := cfg.DB.ListAuditEvents(r.Context(), params)
+		if err != nil {
+			// The status line may already be sent, so the export just ends.
+			fmt.Println("Error exporting audit events:", err)
+			return
+		}
+		for _, e := range events {
+			if err := write(auditEventFromDB(e)); err != nil {
+				return
+			}
+		}
+		if err := flush(); err != nil {
+			return
+		}
+		if len(events) < auditMaxPageSize {
+			return
+		}
+		params.BeforeID = sql.NullInt64{Int64: events[len(events)-1].ID, Valid: true}
+	}
+}
+
+// csvSafe keeps spreadsheets from running client supplied values, such as
+// user agents, as formulas.
+func csvSafe(s string) string {
+	if s != "" && (s[0] == '=' || s[0] == '+' || s[0] == '-' || s[0] == '@' || s[0] == '\t' || s[0] == '\r') {
+		return "'" + s
+	}
+	return s
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/eefret/chirpy/internal/auth"
+	"github.com/eefret/chirpy/internal/database"
+	"github.com/eefret/chirpy/internal/pagination"
+	"github.com/google/uuid"
+)
+
+// maxMutedWords is how many words a user can mute.
+const maxMutedWords = 100
+
+// mutedWordPattern is what a muted word may contain. The database matches
+// muted words as regular expressions, so none of these characters may be
+// special in one.
+var mutedWordPattern = regexp.MustCompile(`^[\p{L}\p{N}_#@' -]{1,50}$`)
+
+// BlockedUser is an entry in a list of blocked or muted users.
+type BlockedUser struct {
+	UserID    uuid.UUID `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type MutedWord struct {
+	ID        uuid.UUID `json:"id"`
+	Word      string    `json:"word"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func mutedWordFromDB(m database.MutedWord) MutedWord {
+	return MutedWord{
+		ID:        m.ID,
+		Word:      m.Word,
+		CreatedAt: m.CreatedAt,
+	}
+}
+
+// nullViewer turns the result of viewer into a query parameter, NULL for
+// anonymous requests.
+func nullViewer(viewer uuid.UUID) uuid.NullUUID {
+	return uuid.NullUUID{UUID: viewer, Valid: viewer != uuid.Nil}
+}
+
+// blocked reports whether either user has blocked the other. Anonymous
+// viewers are never blocked. If the check fails the users are treated as
+// blocked, so a database error never shows someone what a block hides.
+func (cfg *apiConfig) blocked(ctx context.Context, viewer, author uuid.UUID) bool {
+	if viewer == uuid.Nil || viewer == author {
+		return false
+	}
+	blocked, err := cfg.DB.IsBlocked(ctx, database.IsBlockedParams{UserA: viewer, UserB: author})
+	if err != nil {
+		fmt.Println("Error checking block:", err)
+		return true
+	}
+	return blocked
+}
+
+// chirpBlocked reports whether a chirp opened on its own is hidden from
+// viewer by a block with its author or, for a rechirp, with the author of
+// the chirp it reposts. Mutes only hide chirps from listings, so a muted
+// chirp can still be opened directly.
+func (cfg *apiConfig) chirpBlocked(ctx context.Context, viewer uuid.UUID, chirp database.Chirp) bool {
+	if cfg.blocked(ctx, viewer, chirp.UserID) {
+		return true
+	}
+	if !chirp.RechirpOf.Valid {
+		return false
+	}
+	original, err := cfg.DB.GetChirp(ctx, chirp.RechirpOf.UUID)
+	return err == nil && cfg.blocked(ctx, viewer, original.UserID)
+}
+
+// targetUser reads the user a block or mute request is about. On failure
+// the error response has been written and ok is false.
+func (cfg *apiConfig) targetUser(w http.ResponseWriter, r *http.Request, caller principal) (userID uuid.UUID, ok bool) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return uuid.Nil, false
+	}
+	if userID == caller.UserID {
+		respondWithError(w, http.StatusBadRequest, "You cannot do this to yourself")
+		return uuid.Nil, false
+	}
+	if _, err := cfg.DB.GetUser(r.Context(), userID); err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return uuid.Nil, false
+	}
+	return userID, true
+}
+
+// handleBlockUser blocks a user. Neither side sees the other's chirps
+// afterwards or can interact with them, and any follows between them are
+// removed.
+func (cfg *apiConfig) handleBlockUser(w http.ResponseWriter, r *http.Request) {
+	caller, ok := cfg.authenticate(w, r, auth.ScopeProfileWrite)
+	if !ok {
+		return
+	}
+
+	blockedID, ok := cfg.targetUser(w, r, caller)
+	if !ok {
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not block user")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	// Blocking a user twice is not an error.
+	_, err = qtx.CreateBlock(r.Context(), database.CreateBlockParams{
+		BlockerID: caller.UserID,
+		BlockedID: blockedID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not block user")
+		return
+	}
+
+	for _, pair := range [][2]uuid.UUID{{caller.UserID, blockedID}, {blockedID, caller.UserID}} {
+		_, err = qtx.UnfollowUser(r.Context(), database.UnfollowUserParams{
+			FollowerID: pair[0],
+			FolloweeID: pair[1],
+		})
+		if err == nil {
+			err = qtx.RemoveAuthorFromTimeline(r.Context(), database.RemoveAuthorFromTimelineParams{
+				UserID:   pair[0],
+				AuthorID: pair[1],
+			})
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Could not block user")
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not block user")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleUnblockUser lifts a block. Follows removed by the block are not
+// restored.
+func (cfg *apiConfig) handleUnblockUser(w http.ResponseWriter, r *http.Request) {
+	caller, ok := cfg.authenticate(w, r, auth.ScopeProfileWrite)
+	if !ok {
+		return
+	}
+
+	blockedID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	// Unblocking a user that is not blocked is not an error either.
+	_, err = cfg.DB.DeleteBlock(r.Context(), database.DeleteBlockParams{
+		BlockerID: caller.UserID,
+		BlockedID: blockedID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not unblock user")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleMuteUser hides a user's chirps from the caller's listings, timeline
+// and search results. Unlike a block, the muted user is not told apart from
+// anyone else and can still see and interact with the caller's chirps.
+func (cfg *apiConfig) handleMuteUser(w http.ResponseWriter, r *http.Request) {
+	caller, ok := cfg.authenticate(w, r, auth.ScopeProfileWrite)
+	if !ok {
+		return
+	}
+
+	mutedID, ok := cfg.targetUser(w, r, caller)
+	if !ok {
+		return
+	}
+
+	// Muting a user twice is not an error.
+	_, err := cfg.DB.CreateMute(r.Context(), database.CreateMuteParams{
+		MuterID: caller.UserID,
+		MutedID: mutedID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not mute user")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handleUnmuteUser(w http.ResponseWriter, r *http.Request) {
+	caller, ok := cfg.authenticate(w, r, auth.ScopeProfileWrite)
+	if !ok {
+		return
+	}
+
+	mutedID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	_, err = cfg.DB.DeleteMute(r.Context(), database.DeleteMuteParams{
+		MuterID: caller.UserID,
+		MutedID: mutedID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not unmute user")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleListBlocks lists the users the caller has blocked, most recent
+// first.
+func (cfg *apiConfig) handleListBlocks(w http.ResponseWriter, r *http.Request) {
+	cfg.listBlockedUsers(w, r, true)
+}
+
+// handleListMutes lists the users the caller has muted, most recent first.
+func (cfg *apiConfig) handleListMutes(w http.ResponseWriter, r *http.Request) {
+	cfg.listBlockedUsers(w, r, false)
+}
+
+func (cfg *apiConfig) listBlockedUsers(w http.ResponseWriter, r *http.Request, blocks bool) {
+	caller, ok := cfg.authenticate(w, r, "")
+	if !ok {
+		return
+	}
+
+	page, ok := parsePageRequest(w, r)
+	if !ok {
+		return
+	}
+
+	params := database.ListBlocksParams{
+		UserID:          caller.UserID,
+		BeforeCreatedAt: sql.NullTime{Time: page.Cursor.CreatedAt, Valid: page.HasCursor},
+		BeforeID:        uuid.NullUUID{UUID: page.Cursor.ID, Valid: page.HasCursor},
+		MaxRows:         page.maxRows(),
+	}
+	var users []BlockedUser
+	if blocks {
+		rows, err := cfg.DB.ListBlocks(r.Context(), params)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Could not list blocks")
+			return
+		}
+		for _, row := range rows {
+			users = append(users, BlockedUser{UserID: row.BlockedID, CreatedAt: row.CreatedAt})
+		}
+	} else {
+		rows, err := cfg.DB.ListMutes(r.Context(), database.ListMutesParams(params))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Could not list mutes")
+			return
+		}
+		for _, row := range rows {
+			users = append(users, BlockedUser{UserID: row.MutedID, CreatedAt: row.CreatedAt})
+		}
+	}
+
+	type BlockedUsersPage struct {
+		Users      []BlockedUser `json:"users"`
+		NextCursor *string       `json:"next_cursor"`
+	}
+
+	response := BlockedUsersPage{Users: []BlockedUser{}}
+	users, response.NextCursor = nextPage(cfg, w, r, page, users, func(last BlockedUser) string {
+		return pagination.Cursor{CreatedAt: last.CreatedAt, ID: last.UserID}.Encode()
+	})
+	response.Users = append(response.Users, users...)
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// handleListMutedWords lists the caller's muted words alphabetically.
+func (cfg *apiConfig) handleListMutedWords(w http.ResponseWriter, r *http.Request) {
+	caller, ok := cfg.authenticate(w, r, "")
+	if !ok {
+		return
+	}
+
+	words, err := cfg.DB.ListMutedWords(r.Context(), caller.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not list muted words")
+		return
+	}
+
+	response := []MutedWord{}
+	for _, word := range words {
+		response = append(response, mutedWordFromDB(word))
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// handleCreateMutedWord mutes a word or phrase. Chirps that contain it as a
+// whole word, in any case, are hidden from the caller's listings.
+func (cfg *apiConfig) handleCreateMutedWord(w http.ResponseWriter, r *http.Request) {
+	caller, ok := cfg.authenticate(w, r, auth.ScopeProfileWrite)
+	if !ok {
+		return
+	}
+
+	type MutedWordRequest struct {
+		Word string `json:"word"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	request := MutedWordRequest{}
+
+	err := decoder.Decode(&request)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	word := strings.ToLower(strings.TrimSpace(request.Word))
+	if !mutedWordPattern.MatchString(word) {
+		respondWithError(w, http.StatusBadRequest, "Muted words must be 1 to 50 letters, digits, spaces or _#@'- characters")
+		return
+	}
+
+	count, err := cfg.DB.CountMutedWords(r.Context(), caller.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not mute word")
+		return
+	}
+	if count >= maxMutedWords {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("You cannot mute more than %d words", maxMutedWords))
+		return
+	}
+
+	muted, err := cfg.DB.CreateMutedWord(r.Context(), database.CreateMutedWordParams{
+		UserID: caller.UserID,
+		Word:   word,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusConflict, "You have already muted this word")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not mute word")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, mutedWordFromDB(muted))
+}
+
+func (cfg *apiConfig) handleDeleteMutedWord(w http.ResponseWriter, r *http.Request) {
+	caller, ok := cfg.authenticate(w, r, auth.ScopeProfileWrite)
+	if !ok {
+		return
+	}
+
+	wordID, err := uuid.Parse(r.PathValue("wordID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid muted word ID")
+		return
+	}
+
+	deleted, err := cfg.DB.DeleteMutedWord(r.Context(), database.DeleteMutedWordParams{
+		ID:     wordID,
+		UserID: caller.UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not unmute word")
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, http.StatusNotFound, "Muted word not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/eefret/chirpy/internal/auth"
+	"github.com/eefret/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+type ChirpRevision struct {
+	ID      uuid.UUID `json:"id"`
+	ChirpID uuid.UUID `json:"chirp_id"`
+	Body    string    `json:"body"`
+	// CreatedAt is when this version was written and ReplacedAt when an
+	// edit replaced it.
+	CreatedAt  time.Time `json:"created_at"`
+	ReplacedAt time.Time `json:"replaced_at"`
+}
+
+func chirpRevisionFromDB(r database.ChirpRevision) ChirpRevision {
+	return ChirpRevision{
+		ID:         r.ID,
+		ChirpID:    r.ChirpID,
+		Body:       r.Body,
+		CreatedAt:  r.CreatedAt,
+		ReplacedAt: r.ReplacedAt,
+	}
+}
+
+// editWindowFor returns how long after posting a user may edit a chirp, or 0
+// for no limit. Chirpy Red users get the longer of the two windows.
+func (cfg *apiConfig) editWindowFor(u database.User) time.Duration {
+	if cfg.editWindow == 0 {
+		return 0
+	}
+	if u.IsRed && cfg.editWindowRed > cfg.editWindow {
+		return cfg.editWindowRed
+	}
+	return cfg.editWindow
+}
+
+// handleEditChirp replaces the text of a chirp and keeps the old text as a
+// revision. Only the author can edit, and only within the edit window.
+func (cfg *apiConfig) handleEditChirp(w http.ResponseWriter, r *http.Request) {
+	caller, ok := cfg.authenticate(w, r, auth.ScopeChirpsWrite)
+	if !ok {
+		return
+	}
+
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	type EditChirpRequest struct {
+		Body string `json:"body"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	request := EditChirpRequest{}
+
+	err = decoder.Decode(&request)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	cleanedText, err := cleanChirpBody(request.Body)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	u, err := cfg.DB.GetUser(r.Context(), caller.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not edit chirp")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	chirp, err := qtx.GetChirpForUpdate(r.Context(), chirpID)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && chirp.DeletedAt.Valid {
+		respondWithError(w, http.StatusNotFound, "Chirp not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not edit chirp")
+		return
+	}
+
+	if chirp.UserID != u.ID {
+		respondWithError(w, http.StatusForbidden, "You do not have permission to edit this chirp")
+		return
+	}
+
+	if chirp.RechirpOf.Valid {
+		respondWithError(w, http.StatusBadRequest, "Rechirps cannot be edited")
+		return
+	}
+
+	if window := cfg.editWindowFor(u); window > 0 && time.Since(chirp.CreatedAt) > window {
+		respondWithError(w, http.StatusForbidden, "This chirp can no longer be edited")
+		return
+	}
+
+	// Saving the same text again is not a new version.
+	if cleanedText == chirp.Body {
+		response := chirpFromDB(chirp)
+		cfg.fillChirps(r, &response)
+		respondWithJSON(w, http.StatusOK, response)
+		return
+	}
+
+	writtenAt := chirp.CreatedAt
+	if chirp.UpdatedAt.Valid && chirp.UpdatedAt.Time.After(writtenAt) {
+		writtenAt = chirp.UpdatedAt.Time
+	}
+	err = qtx.CreateChirpRevision(r.Context(), database.CreateChirpRevisionParams{
+		ChirpID:   chirp.ID,
+		Body:      chirp.Body,
+		CreatedAt: writtenAt,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not edit chirp")
+		return
+	}
+
+	chirp, err = qtx.UpdateChirpBody(r.Context(), database.UpdateChirpBodyParams{
+		ID:   chirp.ID,
+		Body: cleanedText,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not edit chirp")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not edit chirp")
+		return
+	}
+
+	response := chirpFromDB(chirp)
+	cfg.fillChirps(r, &response)
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// handleChirpHistory lists the earlier versions of a chirp, newest first.
+// The current version is the chirp itself.
+func (cfg *apiConfig) handleChirpHistory(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	chirp, err := cfg.DB.GetChirp(r.Context(), chirpID)
+	if err != nil || chirp.DeletedAt.Valid || cfg.chirpBlocked(r.Context(), cfg.viewer(r), chirp) {
+		respondWithError(w, http.StatusNotFound, "Chirp not found")
+		return
+	}
+
+	revisions, err := cfg.DB.ListChirpRevisions(r.Context(), chirpID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not list revisions")
+		return
+	}
+
+	response := []ChirpRevision{}
+	for _, revision := range revisions {
+		response = append(response, chirpRevisionFromDB(revision))
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
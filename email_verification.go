@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/eefret/chirpy/internal/auth"
+	"github.com/eefret/chirpy/internal/database"
+	"github.com/eefret/chirpy/internal/mailer"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const emailVerificationTTL = 24 * time.Hour
+
+// sendEmailVerification stores a verification token for email and mails the
+// link in the background. Failures are logged; the user can ask for a new link.
+func (cfg *apiConfig) sendEmailVerification(ctx context.Context, userID uuid.UUID, email string) {
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		fmt.Println("Error generating verification token:", err)
+		return
+	}
+
+	err = cfg.DB.CreateEmailVerificationToken(ctx, database.CreateEmailVerificationTokenParams{
+		TokenHash: auth.HashToken(token, cfg.tokenPepper),
+		UserID:    userID,
+		Email:     email,
+		ExpiresAt: time.Now().Add(emailVerificationTTL),
+	})
+	if err != nil {
+		fmt.Println("Error saving verification token:", err)
+		return
+	}
+
+	msg := mailer.Message{
+		To:      email,
+		Subject: "Confirm your Chirpy email address",
+		Body: fmt.Sprintf("Confirm that this is your email address by opening this link within a day:\n%s/app/verify-email.html?token=%s\n\n"+
+			"If you didn't ask for this, you can ignore this email.\n",
+			cfg.publicURL, url.QueryEscape(token)),
+	}
+	go func() {
+		if err := cfg.mailer.Send(context.Background(), msg); err != nil {
+			fmt.Println("Error sending verification email:", err)
+		}
+	}()
+}
+
+// handleVerifyEmail confirms the address a verification token was sent to.
+// For a pending change this is the moment the new address replaces the old one.
+func (cfg *apiConfig) handleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	type VerifyEmailRequest struct {
+		Token string `json:"token"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	request := VerifyEmailRequest{}
+
+	err := decoder.Decode(&request)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	verification, err := cfg.DB.ConsumeEmailVerificationToken(r.Context(), auth.HashToken(request.Token, cfg.tokenPepper))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired verification token")
+		return
+	}
+
+	u, err := cfg.DB.VerifyUserEmail(r.Context(), database.VerifyUserEmailParams{
+		Email: verification.Email,
+		ID:    verification.UserID,
+	})
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			respondWithError(w, http.StatusConflict, "Email is already in use")
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired verification token")
+		return
+	}
+
+	cfg.audit(r, auditEvent{
+		Actor:      u.ID,
+		Action:     auditEmailVerified,
+		TargetType: "user",
+		TargetID:   u.ID.String(),
+		Metadata:   map[string]any{"email": u.Email},
+	})
+
+	respondWithJSON(w, http.StatusOK, userFromDB(u))
+}
+
+// handleResendEmailVerification sends a new link for the pending email, or
+// for the current one if it has never been verified.
+func (cfg *apiConfig) handleResendEmailVerification(w http.ResponseWriter, r *http.Request) {
+	caller, ok := cfg.authenticate(w, r, "")
+	if !ok {
+		return
+	}
+	id := caller.UserID
+
+	u, err := cfg.DB.GetUser(r.Context(), id)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	switch {
+	case u.PendingEmail.Valid:
+		cfg.sendEmailVerification(r.Context(), u.ID, u.PendingEmail.String)
+	case !u.EmailVerifiedAt.Valid:
+		cfg.sendEmailVerification(r.Context(), u.ID, u.Email)
+	default:
+		respondWithError(w, http.StatusConflict, "Email is already verified")
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -1,18 +1,27 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
 	"regexp"
-	"sort"
+	"strings"
 	"time"
 
 	"github.com/eefret/chirpy/internal/auth"
 	"github.com/eefret/chirpy/internal/database"
+	"github.com/eefret/chirpy/internal/events"
+	"github.com/eefret/chirpy/internal/pagination"
 	"github.com/google/uuid"
 )
 
+// User is an account as shown to its owner and to admins. Everyone else
+// sees a Profile, which leaves out the email and account settings.
 type User struct {
 	ID        uuid.UUID `json:"id"`
 	CreatedAt time.Time `json:"created_at"`
@@ -21,6 +30,32 @@ type User struct {
 	Token     string    `json:"token,omitempty"`
 	RefreshToken string `json:"refresh_token,omitempty"`
 	IsRed	 bool      `json:"is_chirpy_red"`
+	Role          string `json:"role"`
+	EmailVerified bool   `json:"email_verified"`
+	PendingEmail  string `json:"pending_email,omitempty"`
+	Handle        *string `json:"handle"`
+	DisplayName   string  `json:"display_name"`
+	Bio           string  `json:"bio"`
+	AvatarURL     string  `json:"avatar_url"`
+	Website       string  `json:"website"`
+}
+
+func userFromDB(u database.User) User {
+	return User{
+		ID:            u.ID,
+		CreatedAt:     u.CreatedAt.Time,
+		UpdatedAt:     u.UpdatedAt.Time,
+		Email:         u.Email,
+		IsRed:         u.IsRed,
+		Role:          u.Role,
+		EmailVerified: u.EmailVerifiedAt.Valid,
+		PendingEmail:  u.PendingEmail.String,
+		Handle:        nullStringPtr(u.Handle),
+		DisplayName:   u.DisplayName,
+		Bio:           u.Bio,
+		AvatarURL:     u.AvatarUrl,
+		Website:       u.Website,
+	}
 }
 
 type Chirp struct {
@@ -29,8 +64,59 @@ type Chirp struct {
 	UpdatedAt time.Time `json:"updated_at"`
 	Body      string    `json:"body"`
 	UserID    uuid.UUID `json:"user_id"`
+	// Edited tells clients to link to the chirp's history.
+	Edited         bool       `json:"edited"`
+	InReplyTo      *uuid.UUID `json:"in_reply_to"`
+	ConversationID uuid.UUID  `json:"conversation_id"`
+	ReplyCount     int32      `json:"reply_count"`
+	LikeCount      int32      `json:"like_count"`
+	// LikedByMe is only set for logged in users.
+	LikedByMe bool `json:"liked_by_me"`
+	// RechirpOf is the chirp a rechirp reposts. A rechirp has no text of
+	// its own.
+	RechirpOf *Chirp `json:"rechirp_of"`
+	// QuotedChirp is the chirp a quote chirp quotes, or null once that
+	// chirp is gone.
+	QuotedChirp *Chirp `json:"quoted_chirp"`
+	// Attachments are the images of the chirp, in order.
+	Attachments []Attachment `json:"attachments"`
+
+	// rechirpOfID and quoteOfID are filled in as RechirpOf and QuotedChirp
+	// by fillChirps.
+	rechirpOfID uuid.NullUUID
+	quoteOfID   uuid.NullUUID
+	// Deleted marks a tombstone: a deleted chirp kept, without its text,
+	// because it has replies.
+	Deleted bool `json:"deleted"`
+}
+
+func chirpFromDB(c database.Chirp) Chirp {
+	chirp := Chirp{
+		ID:             c.ID,
+		CreatedAt:      c.CreatedAt,
+		UpdatedAt:      c.UpdatedAt.Time,
+		Body:           c.Body,
+		UserID:         c.UserID,
+		Edited:         c.UpdatedAt.Valid && c.UpdatedAt.Time.After(c.CreatedAt) && !c.DeletedAt.Valid,
+		ConversationID: c.ConversationID,
+		ReplyCount:     c.ReplyCount,
+		LikeCount:      c.LikeCount,
+		Deleted:        c.DeletedAt.Valid,
+		Attachments:    []Attachment{},
+	}
+	if c.InReplyTo.Valid {
+		chirp.InReplyTo = &c.InReplyTo.UUID
+	}
+	chirp.rechirpOfID = c.RechirpOf
+	chirp.quoteOfID = c.QuoteOf
+	return chirp
 }
 
+const (
+	defaultChirpPageSize = 20
+	maxChirpPageSize     = 100
+)
+
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	dat, err := json.Marshal(payload)
 	if err != nil {
@@ -50,6 +136,23 @@ func respondWithError(w http.ResponseWriter, code int, msg string) {
 	respondWithJSON(w, code, map[string]string{"error": msg})
 }
 
+// clientIP returns the address of the client. Behind a reverse proxy the
+// proxy's own address is useless, so the last X-Forwarded-For hop is used instead.
+func (cfg *apiConfig) clientIP(r *http.Request) string {
+	if cfg.trustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			hops := strings.Split(forwarded, ",")
+			return strings.TrimSpace(hops[len(hops)-1])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 func handleHealth(w http.ResponseWriter, r *http.Request) {
 	// Content-Type: text/plain; charset=utf-8
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
@@ -71,6 +174,41 @@ func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
 	})
 }
 
+type contextKey string
+
+const claimsContextKey contextKey = "claims"
+
+// middlewareRequireRole only lets requests through that carry an access
+// token for a user with at least the given role. The token's claims are
+// available to the handler through claimsFromContext.
+func (cfg *apiConfig) middlewareRequireRole(role string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		claims, err := auth.ValidateJWTClaims(token, cfg.jwtKeys)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+
+		if !auth.HasRole(claims.Role, role) {
+			respondWithError(w, http.StatusForbidden, "Forbidden")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsContextKey, claims)))
+	})
+}
+
+func claimsFromContext(ctx context.Context) *auth.Claims {
+	claims, _ := ctx.Value(claimsContextKey).(*auth.Claims)
+	return claims
+}
+
 func (cfg *apiConfig) handleMetrics(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
@@ -87,80 +225,257 @@ func (cfg *apiConfig) handleMetrics(w http.ResponseWriter, r *http.Request) {
 }
 
 func (cfg *apiConfig) handleReset(w http.ResponseWriter, r *http.Request) {
+	if cfg.platform != "dev" {
+		respondWithError(w, http.StatusForbidden, "Reset is only allowed in development")
+		return
+	}
+
 	cfg.fileserverHits.Store(0)
 	if err := cfg.DB.ClearUsers(r.Context()); err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Failed to clear users")
 		return
 	}
+	cfg.audit(r, auditEvent{Actor: adminActor(r), Action: auditReset})
 	w.WriteHeader(http.StatusOK)
 }
 
+func (cfg *apiConfig) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	// Verifiers may cache the key set and should refetch it when they see an unknown kid.
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, http.StatusOK, cfg.jwtKeys.JWKS())
+}
+
+func (cfg *apiConfig) handleSetUserRole(w http.ResponseWriter, r *http.Request) {
+	type SetUserRoleRequest struct {
+		Role string `json:"role"`
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid User ID")
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	request := SetUserRoleRequest{}
+
+	err = decoder.Decode(&request)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if !auth.ValidRole(request.Role) {
+		respondWithError(w, http.StatusBadRequest, "Invalid role")
+		return
+	}
+
+	u, err := cfg.DB.SetUserRole(r.Context(), database.SetUserRoleParams{
+		ID:   userID,
+		Role: request.Role,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	cfg.audit(r, auditEvent{
+		Actor:      adminActor(r),
+		Action:     auditRoleChanged,
+		TargetType: "user",
+		TargetID:   u.ID.String(),
+		Metadata:   map[string]any{"role": u.Role},
+	})
+
+	respondWithJSON(w, http.StatusOK, userFromDB(u))
+}
+
+const maxChirpLength = 140
+
+var (
+	errChirpTooLong = errors.New("Chirp is too long")
+	errChirpEmpty   = errors.New("Body is required")
+
+	// profaneWords match whole words regardless of case.
+	profaneWords = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)\bkerfuffle\b`),
+		regexp.MustCompile(`(?i)\bsharbert\b`),
+		regexp.MustCompile(`(?i)\bfornax\b`),
+	}
+)
+
+// cleanChirpBody validates the text of a new or edited chirp and returns it
+// with profanity masked. Its errors are meant for the client.
+func cleanChirpBody(body string) (string, error) {
+	if len(body) > maxChirpLength {
+		return "", errChirpTooLong
+	}
+	if body == "" {
+		return "", errChirpEmpty
+	}
+
+	for _, word := range profaneWords {
+		body = word.ReplaceAllString(body, "****")
+	}
+	return body, nil
+}
+
+// checkCanPost reports whether a user may post chirps, which needs a
+// verified email address when REQUIRE_VERIFIED_EMAIL is set. If not, the
+// error response has been written.
+func (cfg *apiConfig) checkCanPost(w http.ResponseWriter, r *http.Request, userID uuid.UUID) bool {
+	if !cfg.requireVerifiedEmail {
+		return true
+	}
+	u, err := cfg.DB.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid token")
+		return false
+	}
+	if !u.EmailVerifiedAt.Valid {
+		respondWithError(w, http.StatusForbidden, "Verify your email address before posting")
+		return false
+	}
+	return true
+}
+
 func (cfg *apiConfig) handleCreateChirp(w http.ResponseWriter, r *http.Request) {
 	type requestBody struct {
 		Body    string    `json:"body"`
+		// InReplyTo is the chirp this one replies to, if any.
+		InReplyTo *uuid.UUID `json:"in_reply_to"`
+		// QuoteOf is the chirp this one quotes, if any.
+		QuoteOf *uuid.UUID `json:"quote_of"`
+		// Attachments are images uploaded with POST /api/media.
+		Attachments []attachmentRequest `json:"attachments"`
 	}
 
 	decoder := json.NewDecoder(r.Body)
 	request := requestBody{}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+	caller, ok := cfg.authenticate(w, r, auth.ScopeChirpsWrite)
+	if !ok {
 		return
 	}
+	id := caller.UserID
 
-	id, err := auth.ValidateJWT(token, cfg.authSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Invalid token")
+	if !cfg.checkCanPost(w, r, id) {
 		return
 	}
 
-	err = decoder.Decode(&request)
+	err := decoder.Decode(&request)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Something went wrong")
 		return
 	}
 
-	if len(request.Body) > 140 {
-		respondWithError(w, http.StatusBadRequest, "Chirp is too long")
+	cleanedText, err := cleanChirpBody(request.Body)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	if request.Body == "" {
-		respondWithError(w, http.StatusBadRequest, "Body is required")
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not create chirp")
 		return
 	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
 
-	profaneWords := []string{"kerfuffle", "sharbert", "fornax"}
-	cleanedText := request.Body
+	if err := cfg.validateAttachments(r, qtx, id, request.Attachments); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
-	for _, word := range profaneWords {
-		// Create case-insensitive replacements for whole words only
-		cleanedText = regexp.MustCompile(`(?i)\b`+word+`\b`).ReplaceAllString(cleanedText, "****")
+	var inReplyTo uuid.NullUUID
+	if request.InReplyTo != nil {
+		// Locking the parent keeps it from being deleted, rather than
+		// tombstoned, while the reply is added.
+		parent, err := qtx.GetChirpForUpdate(r.Context(), *request.InReplyTo)
+		if err == nil && parent.RechirpOf.Valid {
+			parent, err = qtx.GetChirpForUpdate(r.Context(), parent.RechirpOf.UUID)
+		}
+		if err != nil || parent.DeletedAt.Valid || cfg.blocked(r.Context(), id, parent.UserID) {
+			respondWithError(w, http.StatusNotFound, "The chirp you are replying to does not exist")
+			return
+		}
+		inReplyTo = uuid.NullUUID{UUID: parent.ID, Valid: true}
 	}
 
-	chirp, err := cfg.DB.CreateChirp(r.Context(), database.CreateChirpParams{
-		Body:    cleanedText,
-		UserID:  id,
+	var quoteOf uuid.NullUUID
+	if request.QuoteOf != nil {
+		quoted, err := cfg.originalChirp(r.Context(), *request.QuoteOf)
+		if err != nil || cfg.blocked(r.Context(), id, quoted.UserID) {
+			respondWithError(w, http.StatusNotFound, "The chirp you are quoting does not exist")
+			return
+		}
+		quoteOf = uuid.NullUUID{UUID: quoted.ID, Valid: true}
+	}
+
+	chirp, err := qtx.CreateChirp(r.Context(), database.CreateChirpParams{
+		Body:      cleanedText,
+		UserID:    id,
+		InReplyTo: inReplyTo,
+		QuoteOf:   quoteOf,
 	})
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Could not create chirp")
 		return
 	}
-	
-	respondWithJSON(w, http.StatusCreated, Chirp{
-		ID:        chirp.ID,
-		CreatedAt: chirp.CreatedAt.Time,
-		UpdatedAt: chirp.UpdatedAt.Time,
-		Body:      chirp.Body,
-		UserID:    chirp.UserID,
+
+	for i, a := range request.Attachments {
+		err := qtx.CreateChirpAttachment(r.Context(), database.CreateChirpAttachmentParams{
+			ChirpID:  chirp.ID,
+			Position: int32(i),
+			MediaID:  a.MediaID,
+			AltText:  strings.TrimSpace(a.AltText),
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Could not create chirp")
+			return
+		}
+	}
+
+	if inReplyTo.Valid {
+		if err := qtx.IncrementReplyCount(r.Context(), inReplyTo.UUID); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Could not create chirp")
+			return
+		}
+	}
+
+	if err := qtx.FanOutChirp(r.Context(), chirp.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not create chirp")
+		return
+	}
+
+	stored, err := createChirpEvents(r.Context(), qtx, database.CreateChirpEventParams{
+		Type:    events.ChirpCreated,
+		ChirpID: chirp.ID,
+		UserID:  chirp.UserID,
 	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not create chirp")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not create chirp")
+		return
+	}
+	cfg.publishChirpEvents(r, stored)
+
+	response := chirpFromDB(chirp)
+	cfg.fillChirps(r, &response)
+	respondWithJSON(w, http.StatusCreated, response)
 }
 
 func (cfg *apiConfig) handleCreateUser(w http.ResponseWriter, r *http.Request) {
 	type CreateUserRequest struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		// Handle is optional; it can be chosen later with PATCH /api/users.
+		Handle string `json:"handle"`
 	}
 
 	decoder := json.NewDecoder(r.Body)
@@ -177,6 +492,13 @@ func (cfg *apiConfig) handleCreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if request.Handle != "" {
+		if err := validateHandle(request.Handle); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
 	hashedPassword, err := auth.HashPassword(request.Password)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
@@ -186,77 +508,182 @@ func (cfg *apiConfig) handleCreateUser(w http.ResponseWriter, r *http.Request) {
 	u, err := cfg.DB.CreateUser(r.Context(), database.CreateUserParams{
 		Email:          request.Email,
 		HashedPassword: hashedPassword,
+		Handle:         sql.NullString{String: request.Handle, Valid: request.Handle != ""},
 	})
+	if isHandleTaken(err) {
+		respondWithError(w, http.StatusConflict, "Handle is already taken")
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
 		return
 	}
 
-	user := User{
-		ID:        u.ID,
-		CreatedAt: u.CreatedAt.Time,
-		UpdatedAt: u.UpdatedAt.Time,
-		Email:     u.Email,
-		IsRed:    u.IsRed,
-	}
+	cfg.audit(r, auditEvent{
+		Actor:      u.ID,
+		Action:     auditUserCreated,
+		TargetType: "user",
+		TargetID:   u.ID.String(),
+	})
 
-	respondWithJSON(w, http.StatusCreated, user)
+	cfg.sendEmailVerification(r.Context(), u.ID, u.Email)
+
+	respondWithJSON(w, http.StatusCreated, userFromDB(u))
 }
 
+// handleChirps lists chirps in the order they were posted, optionally by
+// one author, given by ID or handle. With limit or cursor the response is a
+// page with a next_cursor and a Link header to the next page. Without them
+// every chirp is returned as a plain array, as before pagination existed.
 func (cfg *apiConfig) handleChirps(w http.ResponseWriter, r *http.Request) {
-	var authorID uuid.UUID
-	var err error
-
-	auid := r.URL.Query().Get("author_id")
+	query := r.URL.Query()
 
-	if auid != "" {
-		authorID, err = uuid.Parse(auid)
+	var authorID uuid.NullUUID
+	if auid := query.Get("author_id"); auid != "" {
+		id, err := uuid.Parse(auid)
 		if err != nil {
 			respondWithError(w, http.StatusBadRequest, "Invalid author ID")
 			return
 		}
+		authorID = uuid.NullUUID{UUID: id, Valid: true}
 	}
-
-	sortOrder := r.URL.Query().Get("sort")
-    if sortOrder == "" {
-        sortOrder = "asc"
-    }
-
-	var chirps []database.Chirp
-	if authorID != uuid.Nil {
-		chirps, err = cfg.DB.GetChirpsByAuthor(r.Context(), authorID)
-		if err != nil {
-			respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+	if handle := query.Get("author_handle"); handle != "" {
+		if authorID.Valid {
+			respondWithError(w, http.StatusBadRequest, "Pass either author_id or author_handle")
 			return
 		}
-	} else {
-		chirps, err = cfg.DB.GetChirps(r.Context())
+		author, err := cfg.DB.GetUserByHandle(r.Context(), handle)
 		if err != nil {
-			respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+			respondWithError(w, http.StatusNotFound, "User not found")
 			return
 		}
+		authorID = uuid.NullUUID{UUID: author.ID, Valid: true}
 	}
 
-	var response []Chirp
-	for _, chirp := range chirps {
-		response = append(response, Chirp{
-			ID:        chirp.ID,
-			CreatedAt: chirp.CreatedAt.Time,
-			UpdatedAt: chirp.UpdatedAt.Time,
-			Body:      chirp.Body,
-			UserID:    chirp.UserID,
-		})
+	page, ok := parsePageRequest(w, r)
+	if !ok {
+		return
 	}
 
-	if sortOrder == "desc" {
-		sort.Slice(response, func(i, j int) bool {
-			return response[i].CreatedAt.After(response[j].CreatedAt)
+	paged := query.Has("limit") || page.HasCursor
+	var maxRows sql.NullInt32
+	if paged {
+		maxRows = sql.NullInt32{Int32: page.maxRows(), Valid: true}
+	}
+
+	var chirps []database.Chirp
+	var err error
+	if query.Get("sort") == "desc" {
+		chirps, err = cfg.DB.ListChirpsDesc(r.Context(), database.ListChirpsDescParams{
+			AuthorID:        authorID,
+			BeforeCreatedAt: sql.NullTime{Time: page.Cursor.CreatedAt, Valid: page.HasCursor},
+			BeforeID:        uuid.NullUUID{UUID: page.Cursor.ID, Valid: page.HasCursor},
+			ViewerID:        nullViewer(cfg.viewer(r)),
+			MaxRows:         maxRows,
+		})
+	} else {
+		chirps, err = cfg.DB.ListChirpsAsc(r.Context(), database.ListChirpsAscParams{
+			AuthorID:       authorID,
+			AfterCreatedAt: sql.NullTime{Time: page.Cursor.CreatedAt, Valid: page.HasCursor},
+			AfterID:        uuid.NullUUID{UUID: page.Cursor.ID, Valid: page.HasCursor},
+			ViewerID:       nullViewer(cfg.viewer(r)),
+			MaxRows:        maxRows,
 		})
 	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	if !paged {
+		var response []Chirp
+		for _, chirp := range chirps {
+			response = append(response, chirpFromDB(chirp))
+		}
+		cfg.fillChirps(r, chirpPtrs(response)...)
+		respondWithJSON(w, http.StatusOK, response)
+		return
+	}
+
+	type ChirpPage struct {
+		Chirps     []Chirp `json:"chirps"`
+		NextCursor *string `json:"next_cursor"`
+	}
+
+	response := ChirpPage{Chirps: []Chirp{}}
+	chirps, response.NextCursor = nextPage(cfg, w, r, page, chirps, func(last database.Chirp) string {
+		return pagination.Cursor{CreatedAt: last.CreatedAt, ID: last.ID}.Encode()
+	})
+	for _, chirp := range chirps {
+		response.Chirps = append(response.Chirps, chirpFromDB(chirp))
+	}
+	cfg.fillChirps(r, chirpPtrs(response.Chirps)...)
 
 	respondWithJSON(w, http.StatusOK, response)
 }
 
+// pageRequest is the limit and cursor of a request for a page of a
+// listing.
+type pageRequest struct {
+	Limit     int
+	Cursor    pagination.Cursor
+	HasCursor bool
+}
+
+// parsePageRequest reads the limit and cursor query parameters. On failure
+// the error response has been written and ok is false.
+func parsePageRequest(w http.ResponseWriter, r *http.Request) (page pageRequest, ok bool) {
+	query := r.URL.Query()
+
+	limit, err := pagination.ParseLimit(query.Get("limit"), defaultChirpPageSize, maxChirpPageSize)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxChirpPageSize))
+		return pageRequest{}, false
+	}
+	page.Limit = limit
+
+	if c := query.Get("cursor"); c != "" {
+		page.Cursor, err = pagination.DecodeCursor(c)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid cursor")
+			return pageRequest{}, false
+		}
+		page.HasCursor = true
+	}
+
+	return page, true
+}
+
+// setNextLink points the Link header at the next page of the listing being
+// requested: the same URL with cursor set to next.
+func (cfg *apiConfig) setNextLink(w http.ResponseWriter, r *http.Request, next string) {
+	base, err := url.Parse(cfg.publicURL + r.URL.Path)
+	if err != nil {
+		return
+	}
+	base.RawQuery = r.URL.RawQuery
+	w.Header().Set("Link", pagination.NextLink(base, next))
+}
+
+// maxRows is how many rows to fetch for the page. The extra row tells
+// whether there is a next page.
+func (p pageRequest) maxRows() int32 {
+	return int32(p.Limit + 1)
+}
+
+// nextPage trims rows fetched with maxRows to the page. If there is a next
+// page, it sets the Link header and returns the next cursor, which cursor
+// makes from the last row kept.
+func nextPage[T any](cfg *apiConfig, w http.ResponseWriter, r *http.Request, page pageRequest, rows []T, cursor func(last T) string) ([]T, *string) {
+	if len(rows) <= page.Limit {
+		return rows, nil
+	}
+	rows = rows[:page.Limit]
+	next := cursor(rows[page.Limit-1])
+	cfg.setNextLink(w, r, next)
+	return rows, &next
+}
+
 func (cfg *apiConfig) handleGetChirp(w http.ResponseWriter, r *http.Request) {
 	chirpID := r.PathValue("chirpID")
 	if chirpID == "" {
@@ -264,25 +691,29 @@ func (cfg *apiConfig) handleGetChirp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	chirp, err := cfg.DB.GetChirp(r.Context(), uuid.MustParse(chirpID))
+	id, err := uuid.Parse(chirpID)
 	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	chirp, err := cfg.DB.GetChirp(r.Context(), id)
+	if err != nil || cfg.chirpBlocked(r.Context(), cfg.viewer(r), chirp) {
 		respondWithError(w, http.StatusNotFound, "Could not retrieve chirp")
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, Chirp{
-		ID:        chirp.ID,
-		CreatedAt: chirp.CreatedAt.Time,
-		UpdatedAt: chirp.UpdatedAt.Time,
-		Body:      chirp.Body,
-		UserID:    chirp.UserID,
-	})
+	response := chirpFromDB(chirp)
+	cfg.fillChirps(r, &response)
+	respondWithJSON(w, http.StatusOK, response)
 }
 
 func (cfg *apiConfig) handleLogin(w http.ResponseWriter, r *http.Request) {
 	type LoginRequest struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		// OAuthConsent asks for a consent token instead of a session.
+		OAuthConsent bool `json:"oauth_consent"`
 	}
 
 	decoder := json.NewDecoder(r.Body)
@@ -299,52 +730,123 @@ func (cfg *apiConfig) handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !cfg.reserveLoginAttempt(w, r, request.Email) {
+		return
+	}
+
 	u, err := cfg.DB.GetUserByEmail(r.Context(), request.Email)
 	if err != nil {
+		auth.CheckDummyPassword(request.Password)
+		cfg.recordLoginFailure(r, request.Email, uuid.Nil, "unknown_email")
 		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password")
 		return
 	}
 
 	err = auth.CheckPasswordHash(request.Password, u.HashedPassword)
 	if err != nil {
+		cfg.recordLoginFailure(r, request.Email, u.ID, "wrong_password")
 		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password")
 		return
 	}
 
-	jwt, err := auth.MakeJWT(u.ID, cfg.authSecret, time.Hour)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Could not get JWT")
+	// Hashes made with bcrypt or older argon2 parameters are replaced while
+	// the plaintext is at hand, so no one has to reset their password.
+	if auth.NeedsRehash(u.HashedPassword) {
+		hashedPassword, err := auth.HashPassword(request.Password)
+		if err == nil {
+			_, err = cfg.DB.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
+				ID:             u.ID,
+				HashedPassword: hashedPassword,
+			})
+		}
+		if err != nil {
+			fmt.Println("Error upgrading password hash:", err)
+		}
+	}
+
+	if u.TotpEnabledAt.Valid {
+		// The code is counted as an attempt of its own.
+		cfg.releaseLoginAttempt(r, u.Email)
+		cfg.respondWithMFAChallenge(w, u)
 		return
 	}
 
-	refreshToken, err := auth.MakeRefreshToken()
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Could not generate refresh token")
+	cfg.clearLoginFailures(r, u.Email)
+	if request.OAuthConsent {
+		cfg.respondWithConsentToken(w, r, u, "password")
 		return
 	}
+	cfg.completeLogin(w, r, u, "password")
+}
 
+// completeLogin starts a session for a user who has passed every login step
+// and responds with the user, an access token and a refresh token. method
+// is the last step the user passed and is recorded in the audit log.
+func (cfg *apiConfig) completeLogin(w http.ResponseWriter, r *http.Request, u database.User, method string) {
+	jwt, err := auth.MakeJWT(u.ID, u.Role, cfg.jwtKeys, accessTokenTTL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not get JWT")
+		return
+	}
 
-	_, err = cfg.DB.SaveRefreshToken(r.Context(), database.SaveRefreshTokenParams{
-		UserID: u.ID,
-		Token: refreshToken,
-		ExpiresAt: time.Now().Add(time.Hour * 24 * 60),
+	// Every login starts a new session, which is a new refresh token family.
+	familyID := uuid.New()
+	refreshToken, err := cfg.issueRefreshToken(r, cfg.DB, database.RefreshToken{
+		UserID:           u.ID,
+		FamilyID:         familyID,
+		SessionStartedAt: time.Now(),
 	})
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Could not save refresh token")
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, User{
-		ID:        u.ID,
-		CreatedAt: u.CreatedAt.Time,
-		UpdatedAt: u.UpdatedAt.Time,
-		IsRed:     u.IsRed,
-		Email:     u.Email,
-		Token:     jwt,
-		RefreshToken: refreshToken,
+	cfg.audit(r, auditEvent{
+		Actor:      u.ID,
+		Action:     auditLoginSucceeded,
+		TargetType: "session",
+		TargetID:   familyID.String(),
+		Metadata:   map[string]any{"method": method},
 	})
+
+	user := userFromDB(u)
+	user.Token = jwt
+	user.RefreshToken = refreshToken
+
+	respondWithJSON(w, http.StatusOK, user)
 }
 
+// issueRefreshToken creates and stores the next refresh token of a session,
+// recording the client that is using the session. The user, family, start
+// time and OAuth grant are taken from session, which is the previous token
+// of the session or a new RefreshToken for a new one.
+func (cfg *apiConfig) issueRefreshToken(r *http.Request, q *database.Queries, session database.RefreshToken) (string, error) {
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = q.SaveRefreshToken(r.Context(), database.SaveRefreshTokenParams{
+		UserID:           session.UserID,
+		TokenHash:        auth.HashToken(refreshToken, cfg.tokenPepper),
+		ExpiresAt:        time.Now().Add(refreshTokenTTL),
+		FamilyID:         session.FamilyID,
+		UserAgent:        r.UserAgent(),
+		Ip:               cfg.clientIP(r),
+		SessionStartedAt: session.SessionStartedAt,
+		ClientID:         session.ClientID,
+		Scopes:           session.Scopes,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return refreshToken, nil
+}
+
+// handleRefresh exchanges a refresh token for a new access token and a new
+// refresh token in the same family. Presenting a refresh token that was
+// already used means it was copied, so the whole family is revoked.
 func (cfg *apiConfig) handleRefresh(w http.ResponseWriter, r *http.Request) {
 	refreshToken, err := auth.GetBearerToken(r.Header)
 	if err != nil {
@@ -352,24 +854,110 @@ func (cfg *apiConfig) handleRefresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := cfg.DB.GetUserFromRefreshToken(r.Context(), refreshToken)
+	tokenHash := auth.HashToken(refreshToken, cfg.tokenPepper)
+	stored, err := cfg.DB.GetRefreshToken(r.Context(), tokenHash)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	// Refresh tokens of OAuth clients are refreshed at /oauth/token, which
+	// keeps their scopes.
+	if !stored.ExpiresAt.After(time.Now()) || stored.ClientID.Valid {
+		respondWithError(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	newRefreshToken, err := cfg.rotateRefreshToken(r, stored)
+	if errors.Is(err, errRefreshTokenReused) {
+		respondWithError(w, http.StatusUnauthorized, "Refresh token reuse detected")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not refresh token")
+		return
+	}
+
+	// Roles can change while a session lives, so read the current one.
+	u, err := cfg.DB.GetUser(r.Context(), stored.UserID)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Invalid token")
 		return
 	}
 
-	jwt, err := auth.MakeJWT(user.ID, cfg.authSecret, time.Hour)
+	jwt, err := auth.MakeJWT(u.ID, u.Role, cfg.jwtKeys, accessTokenTTL)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Could not get JWT")
 		return
 	}
 
 	respondWithJSON(w, http.StatusOK, struct{
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}{
-		Token: jwt,
+		Token:        jwt,
+		RefreshToken: newRefreshToken,
+	})
+
+}
+
+var errRefreshTokenReused = errors.New("refresh token reuse detected")
+
+// rotateRefreshToken consumes a refresh token and stores its successor in
+// the same session. Presenting a refresh token that was already used means
+// it was copied, so the whole family is revoked and errRefreshTokenReused is
+// returned.
+func (cfg *apiConfig) rotateRefreshToken(r *http.Request, stored database.RefreshToken) (string, error) {
+	if stored.RevokedAt.Valid {
+		return "", cfg.revokeReusedRefreshTokenFamily(r, stored)
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	consumed, err := qtx.ConsumeRefreshToken(r.Context(), stored.TokenHash)
+	if err != nil {
+		return "", err
+	}
+	if consumed == 0 {
+		// Another request used the same token between our read and this update.
+		tx.Rollback()
+		return "", cfg.revokeReusedRefreshTokenFamily(r, stored)
+	}
+
+	newRefreshToken, err := cfg.issueRefreshToken(r, qtx, stored)
+	if err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return newRefreshToken, nil
+}
+
+func (cfg *apiConfig) revokeReusedRefreshTokenFamily(r *http.Request, stored database.RefreshToken) error {
+	if err := cfg.DB.RevokeRefreshTokenFamily(r.Context(), stored.FamilyID); err != nil {
+		return err
+	}
+
+	// Whoever presented the token is unknown; it may be the thief or the user.
+	metadata := map[string]any{"user_id": stored.UserID}
+	if stored.ClientID.Valid {
+		metadata["client_id"] = stored.ClientID.UUID
+	}
+	cfg.audit(r, auditEvent{
+		Action:     auditRefreshTokenReused,
+		TargetType: "session",
+		TargetID:   stored.FamilyID.String(),
+		Metadata:   metadata,
 	})
 
+	return errRefreshTokenReused
 }
 
 func (cfg *apiConfig) handleRevoke(w http.ResponseWriter, r *http.Request) {
@@ -379,27 +967,42 @@ func (cfg *apiConfig) handleRevoke(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = cfg.DB.RevokeRefreshToken(r.Context(), refreshToken)
+	tokenHash := auth.HashToken(refreshToken, cfg.tokenPepper)
+	stored, err := cfg.DB.GetRefreshToken(r.Context(), tokenHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Revoking an unknown token changes nothing, so there is nothing to record.
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Could not revoke token")
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
-}
-
-func (cfg *apiConfig) handlePutUser(w http.ResponseWriter, r *http.Request) {
-	authToken, err := auth.GetBearerToken(r.Header)
+	err = cfg.DB.RevokeRefreshToken(r.Context(), tokenHash)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		respondWithError(w, http.StatusInternalServerError, "Could not revoke token")
 		return
 	}
 
-	id, err := auth.ValidateJWT(authToken, cfg.authSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Invalid token")
+	if !stored.RevokedAt.Valid {
+		cfg.audit(r, auditEvent{
+			Actor:      stored.UserID,
+			Action:     auditSessionRevoked,
+			TargetType: "session",
+			TargetID:   stored.FamilyID.String(),
+		})
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlePutUser(w http.ResponseWriter, r *http.Request) {
+	caller, ok := cfg.authenticate(w, r, auth.ScopeProfileWrite)
+	if !ok {
 		return
 	}
+	id := caller.UserID
 
 	type UpdateUserRequest struct {
 		Email    string `json:"email"`
@@ -409,37 +1012,105 @@ func (cfg *apiConfig) handlePutUser(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	request := UpdateUserRequest{}
 
-	err = decoder.Decode(&request)
+	err := decoder.Decode(&request)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
 
-	hashedPassword, err := auth.HashPassword(request.Password)
+	user, err := cfg.DB.GetUser(r.Context(), id)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		respondWithError(w, http.StatusNotFound, "User not found")
 		return
 	}
 
-	user, err := cfg.DB.UpdateUser(r.Context(), database.UpdateUserParams{
-		ID:              id,
-		Email:           request.Email,
-		HashedPassword:  hashedPassword,
-	})
-
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Could not update user")
+	// Leaving out the password keeps it; only a different one is a change.
+	changePassword := request.Password != "" && auth.CheckPasswordHash(request.Password, user.HashedPassword) != nil
+	// A new email only replaces the current one once it has been confirmed.
+	// Asking for the current email again cancels a pending change.
+	cancelEmail := request.Email == user.Email && user.PendingEmail.Valid
+	changeEmail := request.Email != "" && request.Email != user.Email && request.Email != user.PendingEmail.String
+
+	// A leaked personal access token or a rogue OAuth client must not be
+	// enough to take over the account.
+	if changePassword && caller.Scopes != nil {
+		respondWithError(w, http.StatusForbidden, "Log in to change your password")
+		return
+	}
+	// Nor must the email, or it could be pointed at an address the token's
+	// holder controls and used to reset the password.
+	if (changeEmail || cancelEmail) && caller.Scopes != nil {
+		respondWithError(w, http.StatusForbidden, "Log in to change your email")
 		return
 	}
 
+	// Everything that can fail the request is checked before anything is
+	// written, so a rejected request changes nothing.
+	if changeEmail {
+		if _, err := cfg.DB.GetUserByEmail(r.Context(), request.Email); err == nil {
+			respondWithError(w, http.StatusConflict, "Email is already in use")
+			return
+		}
+	}
 
-	respondWithJSON(w, http.StatusOK, User{
-		ID:        user.ID,
-		CreatedAt: user.CreatedAt.Time,
-		UpdatedAt: user.UpdatedAt.Time,
-		IsRed:   user.IsRed,
-		Email:     user.Email,
-	})
+	if changePassword {
+		hashedPassword, err := auth.HashPassword(request.Password)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+			return
+		}
+
+		user, err = cfg.DB.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
+			ID:             id,
+			HashedPassword: hashedPassword,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Could not update user")
+			return
+		}
+
+		// Anyone holding a session may have known the old password.
+		if err := cfg.DB.RevokeAllSessions(r.Context(), user.ID); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Could not revoke sessions")
+			return
+		}
+
+		cfg.audit(r, auditEvent{
+			Actor:      id,
+			Action:     auditPasswordChanged,
+			TargetType: "user",
+			TargetID:   id.String(),
+		})
+	}
+
+	if cancelEmail {
+		user, err = cfg.DB.SetPendingEmail(r.Context(), database.SetPendingEmailParams{ID: id})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Could not update user")
+			return
+		}
+	} else if changeEmail {
+		user, err = cfg.DB.SetPendingEmail(r.Context(), database.SetPendingEmailParams{
+			ID:           id,
+			PendingEmail: sql.NullString{String: request.Email, Valid: true},
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Could not update user")
+			return
+		}
+
+		cfg.audit(r, auditEvent{
+			Actor:      id,
+			Action:     auditEmailChangeRequested,
+			TargetType: "user",
+			TargetID:   id.String(),
+			Metadata:   map[string]any{"email": request.Email},
+		})
+
+		cfg.sendEmailVerification(r.Context(), user.ID, request.Email)
+	}
+
+	respondWithJSON(w, http.StatusOK, userFromDB(user))
 }
 
 
@@ -450,20 +1121,30 @@ func (cfg *apiConfig) handleDeleteChirp(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	authToken, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+	caller, ok := cfg.authenticate(w, r, auth.ScopeChirpsWrite)
+	if !ok {
 		return
 	}
+	id := caller.UserID
 
-	id, err := auth.ValidateJWT(authToken, cfg.authSecret)
+	parsedID, err := uuid.Parse(chirpID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Invalid token")
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
 		return
 	}
 
-	chirp, err := cfg.DB.GetChirp(r.Context(), uuid.MustParse(chirpID))
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
 	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not delete chirp")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	// The lock keeps replies from being added while deciding whether a
+	// tombstone is needed.
+	chirp, err := qtx.GetChirpForUpdate(r.Context(), parsedID)
+	if err != nil || chirp.DeletedAt.Valid {
 		respondWithError(w, http.StatusNotFound, "Chirp not found")
 		return
 	}
@@ -473,12 +1154,73 @@ func (cfg *apiConfig) handleDeleteChirp(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = cfg.DB.DeleteChirp(r.Context(), chirp.ID)
+	attachments, err := qtx.ListChirpAttachments(r.Context(), []uuid.UUID{chirp.ID})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not delete chirp")
+		return
+	}
+
+	// Rechirps go with the chirp either way. Deleting them here, rather than
+	// leaving it to the foreign key, tells streams about each of them.
+	rechirps, err := qtx.DeleteRechirpsOf(r.Context(), uuid.NullUUID{UUID: chirp.ID, Valid: true})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not delete chirp")
+		return
+	}
+
+	if chirp.ReplyCount > 0 {
+		// Replies keep pointing at the chirp; only its text goes, including
+		// earlier versions.
+		err = qtx.TombstoneChirp(r.Context(), chirp.ID)
+		if err == nil {
+			err = qtx.DeleteChirpRevisions(r.Context(), chirp.ID)
+		}
+		if err == nil {
+			err = qtx.DeleteChirpAttachments(r.Context(), chirp.ID)
+		}
+	} else {
+		err = qtx.DeleteChirp(r.Context(), chirp.ID)
+		if err == nil && chirp.InReplyTo.Valid {
+			err = qtx.DecrementReplyCount(r.Context(), chirp.InReplyTo.UUID)
+		}
+	}
+	// Images no other chirp uses go with the chirp.
+	var removed []database.Media
+	if err == nil && len(attachments) > 0 {
+		mediaIDs := make([]uuid.UUID, 0, len(attachments))
+		for _, a := range attachments {
+			mediaIDs = append(mediaIDs, a.Media.ID)
+		}
+		removed, err = qtx.DeleteUnattachedMedia(r.Context(), mediaIDs)
+	}
+	var stored []database.ChirpEvent
+	if err == nil {
+		params := []database.CreateChirpEventParams{{
+			Type:    events.ChirpDeleted,
+			ChirpID: chirp.ID,
+			UserID:  chirp.UserID,
+		}}
+		for _, rechirp := range rechirps {
+			params = append(params, database.CreateChirpEventParams{
+				Type:    events.ChirpDeleted,
+				ChirpID: rechirp.ID,
+				UserID:  rechirp.UserID,
+			})
+		}
+		stored, err = createChirpEvents(r.Context(), qtx, params...)
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Could not delete chirp")
 		return
 	}
 
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not delete chirp")
+		return
+	}
+	cfg.publishChirpEvents(r, stored)
+	cfg.deleteMediaBlobs(r.Context(), removed)
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -491,8 +1233,6 @@ func (cfg *apiConfig) handlePolkaWebhook(w http.ResponseWriter, r *http.Request)
 		} `json:"data"`
 	}
 
-	fmt.Println(r.Header.Get("Authorization"))
-
 	apiKey, err := auth.GetAPIKey(r.Header)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
@@ -529,5 +1269,12 @@ func (cfg *apiConfig) handlePolkaWebhook(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	cfg.audit(r, auditEvent{
+		Action:     auditUserUpgraded,
+		TargetType: "user",
+		TargetID:   userID.String(),
+		Metadata:   map[string]any{"source": "polka"},
+	})
+
 	respondWithJSON(w, http.StatusNoContent, nil)
 }
\ No newline at end of file
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/eefret/chirpy/internal/auth"
+	"github.com/eefret/chirpy/internal/database"
+	"github.com/eefret/chirpy/internal/pagination"
+	"github.com/google/uuid"
+)
+
+// timelineBackfill is how many of a user's latest chirps are added to a
+// timeline when they are followed.
+const timelineBackfill = 100
+
+// Follow is an entry in a list of followers or followed users.
+type Follow struct {
+	UserID     uuid.UUID `json:"user_id"`
+	FollowedAt time.Time `json:"followed_at"`
+}
+
+func (cfg *apiConfig) handleFollowUser(w http.ResponseWriter, r *http.Request) {
+	caller, ok := cfg.authenticate(w, r, auth.ScopeProfileWrite)
+	if !ok {
+		return
+	}
+
+	followeeID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	if followeeID == caller.UserID {
+		respondWithError(w, http.StatusBadRequest, "You cannot follow yourself")
+		return
+	}
+
+	if _, err := cfg.DB.GetUser(r.Context(), followeeID); err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	if cfg.blocked(r.Context(), caller.UserID, followeeID) {
+		respondWithError(w, http.StatusForbidden, "You cannot follow this user")
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not follow user")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	// Following a user twice is not an error.
+	followed, err := qtx.FollowUser(r.Context(), database.FollowUserParams{
+		FollowerID: caller.UserID,
+		FolloweeID: followeeID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not follow user")
+		return
+	}
+
+	if followed > 0 {
+		err = qtx.BackfillTimeline(r.Context(), database.BackfillTimelineParams{
+			UserID:   caller.UserID,
+			AuthorID: followeeID,
+			MaxRows:  timelineBackfill,
+		})
+		if err == nil {
+			err = qtx.MarkFanoutOnRead(r.Context(), database.MarkFanoutOnReadParams{
+				ID:            followeeID,
+				FollowerCount: cfg.fanoutLimit,
+			})
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Could not follow user")
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not follow user")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handleUnfollowUser(w http.ResponseWriter, r *http.Request) {
+	caller, ok := cfg.authenticate(w, r, auth.ScopeProfileWrite)
+	if !ok {
+		return
+	}
+
+	followeeID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not unfollow user")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	// Unfollowing a user that is not followed is not an error either.
+	_, err = qtx.UnfollowUser(r.Context(), database.UnfollowUserParams{
+		FollowerID: caller.UserID,
+		FolloweeID: followeeID,
+	})
+	if err == nil {
+		err = qtx.RemoveAuthorFromTimeline(r.Context(), database.RemoveAuthorFromTimelineParams{
+			UserID:   caller.UserID,
+			AuthorID: followeeID,
+		})
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not unfollow user")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not unfollow user")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleFollowers lists who follows a user, most recent first.
+func (cfg *apiConfig) handleFollowers(w http.ResponseWriter, r *http.Request) {
+	cfg.listFollows(w, r, true)
+}
+
+// handleFollowing lists who a user follows, most recent first.
+func (cfg *apiConfig) handleFollowing(w http.ResponseWriter, r *http.Request) {
+	cfg.listFollows(w, r, false)
+}
+
+func (cfg *apiConfig) listFollows(w http.ResponseWriter, r *http.Request, followers bool) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	page, ok := parsePageRequest(w, r)
+	if !ok {
+		return
+	}
+
+	u, err := cfg.DB.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	params := database.ListFollowersParams{
+		UserID:          u.ID,
+		BeforeCreatedAt: sql.NullTime{Time: page.Cursor.CreatedAt, Valid: page.HasCursor},
+		BeforeID:        uuid.NullUUID{UUID: page.Cursor.ID, Valid: page.HasCursor},
+		MaxRows:         page.maxRows(),
+	}
+	var follows []database.Follow
+	count := u.FollowerCount
+	if followers {
+		follows, err = cfg.DB.ListFollowers(r.Context(), params)
+	} else {
+		follows, err = cfg.DB.ListFollowing(r.Context(), database.ListFollowingParams(params))
+		count = u.FollowingCount
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not list follows")
+		return
+	}
+
+	type FollowsPage struct {
+		Users      []Follow `json:"users"`
+		Count      int32    `json:"count"`
+		NextCursor *string  `json:"next_cursor"`
+	}
+
+	// The other side of each follow is the user listed.
+	other := func(f database.Follow) uuid.UUID {
+		if followers {
+			return f.FollowerID
+		}
+		return f.FolloweeID
+	}
+
+	response := FollowsPage{Users: []Follow{}, Count: count}
+	follows, response.NextCursor = nextPage(cfg, w, r, page, follows, func(last database.Follow) string {
+		return pagination.Cursor{CreatedAt: last.CreatedAt, ID: other(last)}.Encode()
+	})
+	for _, f := range follows {
+		response.Users = append(response.Users, Follow{UserID: other(f), FollowedAt: f.CreatedAt})
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
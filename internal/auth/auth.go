@@ -1,65 +1,193 @@
 package auth
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 )
 
-func HashPassword(password string) (string, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+// Roles, from least to most privileged.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var roleRank = map[string]int{
+	RoleUser:      1,
+	RoleModerator: 2,
+	RoleAdmin:     3,
+}
+
+// ValidRole reports whether role is one of the known roles.
+func ValidRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
+
+// HasRole reports whether a user with role have may act with role want.
+// Each role includes the privileges of the roles below it.
+func HasRole(have, want string) bool {
+	return roleRank[have] > 0 && roleRank[have] >= roleRank[want]
+}
+
+// Claims are the claims carried by Chirpy access tokens.
+type Claims struct {
+	jwt.RegisteredClaims
+	Role string `json:"role,omitempty"`
+	// Scope and ClientID are only set on tokens issued to OAuth clients.
+	Scope    string `json:"scope,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
+}
+
+// UserID returns the user the token was issued to.
+func (c *Claims) UserID() (uuid.UUID, error) {
+	return uuid.Parse(c.Subject)
+}
+
+// MakeJWT creates a JWT for a given user ID and role, signed with the active key of the key ring.
+func MakeJWT(userID uuid.UUID, role string, keys *KeyRing, expiresIn time.Duration) (string, error) {
+	now := time.Now()
+
+	claims := &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "chirpy",
+			Subject:   userID.String(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+		},
+		Role: role,
+	}
+
+	return signJWT(claims, keys)
+}
+
+// ValidateJWT verifies a JWT against the key named by its kid header and extracts the user ID.
+func ValidateJWT(tokenString string, keys *KeyRing) (uuid.UUID, error) {
+	claims, err := ValidateJWTClaims(tokenString, keys)
 	if err != nil {
-		return "", err
+		return uuid.Nil, err
+	}
+
+	return claims.UserID()
+}
+
+// ValidateJWTClaims verifies an access token like ValidateJWT and returns all of its claims.
+func ValidateJWTClaims(tokenString string, keys *KeyRing) (*Claims, error) {
+	claims, err := parseJWT(tokenString, keys)
+	if err != nil {
+		return nil, err
 	}
-	return string(hashedPassword), nil
+
+	// MFA challenge and consent tokens are only good for one step; they must not grant access.
+	if slices.Contains(claims.Audience, MFAAudience) || slices.Contains(claims.Audience, ConsentAudience) {
+		return nil, jwt.ErrTokenInvalidAudience
+	}
+
+	if _, err := claims.UserID(); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// MFAAudience marks tokens that are only good for completing a two-factor login.
+const MFAAudience = "chirpy-mfa"
+
+// MakeMFAToken creates a short-lived token proving that the user passed the
+// password step of a login that still needs a second factor.
+func MakeMFAToken(userID uuid.UUID, keys *KeyRing, expiresIn time.Duration) (string, error) {
+	return makeAudienceToken(userID, MFAAudience, keys, expiresIn)
 }
 
-func CheckPasswordHash(password, hash string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+// ValidateMFAToken verifies a token from MakeMFAToken and extracts the user ID.
+func ValidateMFAToken(tokenString string, keys *KeyRing) (uuid.UUID, error) {
+	return validateAudienceToken(tokenString, MFAAudience, keys)
 }
 
-// MakeJWT creates a signed JWT for a given user ID.
-func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
+// ConsentAudience marks tokens that are only good for answering an OAuth
+// authorization request.
+const ConsentAudience = "chirpy-oauth-consent"
+
+// MakeConsentToken creates a short-lived token proving that the user logged
+// in on the OAuth consent page, without starting a session.
+func MakeConsentToken(userID uuid.UUID, keys *KeyRing, expiresIn time.Duration) (string, error) {
+	return makeAudienceToken(userID, ConsentAudience, keys, expiresIn)
+}
+
+// ValidateConsentToken verifies a token from MakeConsentToken and extracts the user ID.
+func ValidateConsentToken(tokenString string, keys *KeyRing) (uuid.UUID, error) {
+	return validateAudienceToken(tokenString, ConsentAudience, keys)
+}
+
+func makeAudienceToken(userID uuid.UUID, audience string, keys *KeyRing, expiresIn time.Duration) (string, error) {
 	now := time.Now()
 
-	claims := jwt.RegisteredClaims{
-		Issuer:    "chirpy",
-		Subject:   userID.String(),
-		IssuedAt:  jwt.NewNumericDate(now),
-		ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+	claims := &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "chirpy",
+			Subject:   userID.String(),
+			Audience:  jwt.ClaimStrings{audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(tokenSecret))
+	return signJWT(claims, keys)
 }
 
-// ValidateJWT verifies a JWT and extracts the user ID.
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(tokenSecret), nil
-	})
+func validateAudienceToken(tokenString, audience string, keys *KeyRing) (uuid.UUID, error) {
+	claims, err := parseJWT(tokenString, keys, jwt.WithAudience(audience))
 	if err != nil {
 		return uuid.Nil, err
 	}
 
-	claims, ok := token.Claims.(*jwt.RegisteredClaims)
-	if !ok || !token.Valid {
-		return uuid.Nil, jwt.ErrSignatureInvalid
+	return claims.UserID()
+}
+
+func signJWT(claims *Claims, keys *KeyRing) (string, error) {
+	key := keys.active()
+	if key == nil {
+		return "", ErrUnknownKey
 	}
 
-	userID, err := uuid.Parse(claims.Subject)
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.private)
+}
+
+func parseJWT(tokenString string, keys *KeyRing, opts ...jwt.ParserOption) (*Claims, error) {
+	opts = append(opts, jwt.WithValidMethods([]string{AlgRS256, AlgEdDSA}))
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := keys.lookup(kid)
+		if err != nil {
+			return nil, err
+		}
+		if token.Method.Alg() != key.Algorithm {
+			return nil, jwt.ErrTokenSignatureInvalid
+		}
+		return key.Public(), nil
+	}, opts...)
 	if err != nil {
-		return uuid.Nil, err
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid {
+		return nil, jwt.ErrSignatureInvalid
 	}
 
-	return userID, nil
+	return claims, nil
 }
 
 func GetBearerToken(headers http.Header) (string, error) {
@@ -90,6 +218,40 @@ func GetAPIKey(headers http.Header) (string, error) {
 	return parts[1], nil
 }
 
+// Scopes that can be granted to personal access tokens.
+const (
+	ScopeChirpsRead   = "chirps:read"
+	ScopeChirpsWrite  = "chirps:write"
+	ScopeProfileWrite = "profile:write"
+)
+
+var scopes = []string{ScopeChirpsRead, ScopeChirpsWrite, ScopeProfileWrite}
+
+// ValidScope reports whether scope is one of the known scopes.
+func ValidScope(scope string) bool {
+	return slices.Contains(scopes, scope)
+}
+
+// personalAccessTokenPrefix lets GetBearerToken callers tell personal access tokens from JWTs
+// and makes leaked tokens easy to spot by secret scanners.
+const personalAccessTokenPrefix = "chirpy_pat_"
+
+// MakePersonalAccessToken generates a random long-lived token for scripts and bots.
+func MakePersonalAccessToken() (string, error) {
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return personalAccessTokenPrefix + hex.EncodeToString(randomBytes), nil
+}
+
+// IsPersonalAccessToken reports whether a bearer token is a personal access token rather than a JWT.
+func IsPersonalAccessToken(token string) bool {
+	return strings.HasPrefix(token, personalAccessTokenPrefix)
+}
+
 func MakeRefreshToken() (string, error) {
 	// rand.Read to generate 32 bytes (256 bits) of random data from the crypto/rand package (math/rand’s Read function is deprecated).
 	randomBytes := make([]byte, 32)
@@ -101,4 +263,12 @@ func MakeRefreshToken() (string, error) {
 	// hex.EncodeToString to convert the random data to a hex string
 	token := hex.EncodeToString(randomBytes)
 	return token, nil
+}
+
+// HashToken returns the keyed hash of an opaque token that is stored instead of the token itself.
+// The pepper lives outside the database, so a leaked table cannot be used to forge lookups.
+func HashToken(token, pepper string) string {
+	mac := hmac.New(sha256.New, []byte(pepper))
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
 }
\ No newline at end of file
@@ -1,12 +1,12 @@
 package auth
 
 import (
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
-	"golang.org/x/crypto/bcrypt"
 )
 
 func TestHashPassword(t *testing.T) {
@@ -16,7 +16,11 @@ func TestHashPassword(t *testing.T) {
 		t.Fatalf("expected no error, got %v", err)
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password)); err != nil {
+	if !strings.HasPrefix(hashedPassword, "$argon2id$v=19$m=65536,t=3,p=2$") {
+		t.Fatalf("expected an argon2id hash, got %q", hashedPassword)
+	}
+
+	if err := CheckPasswordHash(password, hashedPassword); err != nil {
 		t.Fatal("hashed password does not match original password")
 	}
 }
@@ -37,38 +41,138 @@ func TestCheckPasswordHash(t *testing.T) {
 
 // TestMakeJWT ensures that a token can be created and validated successfully.
 func TestMakeJWT(t *testing.T) {
-	tokenSecret := "supersecret"
+	keys := newTestKeyRing(t, AlgEdDSA)
 	userID := uuid.New()
 	expiresIn := time.Minute
 
-	token, err := MakeJWT(userID, tokenSecret, expiresIn)
+	token, err := MakeJWT(userID, RoleUser, keys, expiresIn)
 	assert.NoError(t, err)
 	assert.NotEmpty(t, token)
 
-	parsedUserID, err := ValidateJWT(token, tokenSecret)
+	parsedUserID, err := ValidateJWT(token, keys)
 	assert.NoError(t, err)
 	assert.Equal(t, userID, parsedUserID)
 }
 
 // TestValidateJWTInvalidToken ensures that an invalid token is rejected.
 func TestValidateJWTInvalidToken(t *testing.T) {
-	tokenSecret := "supersecret"
+	keys := newTestKeyRing(t, AlgEdDSA)
 	invalidToken := "invalid.token.here"
 
-	_, err := ValidateJWT(invalidToken, tokenSecret)
+	_, err := ValidateJWT(invalidToken, keys)
 	assert.Error(t, err)
 }
 
 // TestValidateJWTExpiredToken ensures that expired tokens are rejected.
 func TestValidateJWTExpiredToken(t *testing.T) {
-	tokenSecret := "supersecret"
+	keys := newTestKeyRing(t, AlgEdDSA)
 	userID := uuid.New()
 	expiredTime := -time.Minute // Token expired 1 minute ago
 
-	token, err := MakeJWT(userID, tokenSecret, expiredTime)
+	token, err := MakeJWT(userID, RoleUser, keys, expiredTime)
 	assert.NoError(t, err)
 
-	_, err = ValidateJWT(token, tokenSecret)
+	_, err = ValidateJWT(token, keys)
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "token is expired")
 }
+
+// TestHashToken ensures token hashes are deterministic and depend on the pepper.
+func TestHashToken(t *testing.T) {
+	token, err := MakeRefreshToken()
+	assert.NoError(t, err)
+
+	hash := HashToken(token, "pepper")
+	assert.Len(t, hash, 64)
+	assert.Equal(t, hash, HashToken(token, "pepper"))
+	assert.NotEqual(t, hash, HashToken(token, "other pepper"))
+	assert.NotEqual(t, token, hash)
+}
+
+// TestMFAToken ensures MFA challenge tokens cannot be used as access tokens and vice versa.
+func TestMFAToken(t *testing.T) {
+	keys := newTestKeyRing(t, AlgEdDSA)
+	userID := uuid.New()
+
+	mfaToken, err := MakeMFAToken(userID, keys, time.Minute)
+	assert.NoError(t, err)
+
+	parsedUserID, err := ValidateMFAToken(mfaToken, keys)
+	assert.NoError(t, err)
+	assert.Equal(t, userID, parsedUserID)
+
+	_, err = ValidateJWT(mfaToken, keys)
+	assert.Error(t, err)
+
+	accessToken, err := MakeJWT(userID, RoleUser, keys, time.Minute)
+	assert.NoError(t, err)
+
+	_, err = ValidateMFAToken(accessToken, keys)
+	assert.Error(t, err)
+}
+
+// TestConsentToken ensures consent tokens are neither access tokens nor MFA tokens.
+func TestConsentToken(t *testing.T) {
+	keys := newTestKeyRing(t, AlgEdDSA)
+	userID := uuid.New()
+
+	consentToken, err := MakeConsentToken(userID, keys, time.Minute)
+	assert.NoError(t, err)
+
+	parsedUserID, err := ValidateConsentToken(consentToken, keys)
+	assert.NoError(t, err)
+	assert.Equal(t, userID, parsedUserID)
+
+	_, err = ValidateJWT(consentToken, keys)
+	assert.Error(t, err)
+
+	_, err = ValidateMFAToken(consentToken, keys)
+	assert.Error(t, err)
+
+	mfaToken, err := MakeMFAToken(userID, keys, time.Minute)
+	assert.NoError(t, err)
+
+	_, err = ValidateConsentToken(mfaToken, keys)
+	assert.Error(t, err)
+}
+
+// TestMakeJWTRole ensures the role survives a round trip through the token.
+func TestMakeJWTRole(t *testing.T) {
+	keys := newTestKeyRing(t, AlgEdDSA)
+	userID := uuid.New()
+
+	token, err := MakeJWT(userID, RoleModerator, keys, time.Minute)
+	assert.NoError(t, err)
+
+	claims, err := ValidateJWTClaims(token, keys)
+	assert.NoError(t, err)
+	assert.Equal(t, RoleModerator, claims.Role)
+
+	parsedUserID, err := claims.UserID()
+	assert.NoError(t, err)
+	assert.Equal(t, userID, parsedUserID)
+}
+
+func TestHasRole(t *testing.T) {
+	assert.True(t, HasRole(RoleAdmin, RoleModerator))
+	assert.True(t, HasRole(RoleModerator, RoleModerator))
+	assert.False(t, HasRole(RoleUser, RoleModerator))
+	assert.False(t, HasRole("", RoleUser))
+	assert.False(t, HasRole("superuser", RoleUser))
+}
+
+func TestMakePersonalAccessToken(t *testing.T) {
+	token, err := MakePersonalAccessToken()
+	assert.NoError(t, err)
+	assert.True(t, IsPersonalAccessToken(token))
+
+	keys := newTestKeyRing(t, AlgEdDSA)
+	jwt, err := MakeJWT(uuid.New(), RoleUser, keys, time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, IsPersonalAccessToken(jwt))
+}
+
+func TestValidScope(t *testing.T) {
+	assert.True(t, ValidScope(ScopeChirpsWrite))
+	assert.False(t, ValidScope("admin"))
+}
@@ -0,0 +1,424 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Supported JWT signing algorithms.
+const (
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+const rsaKeyBits = 2048
+
+// reloadInterval limits how often an unknown kid triggers a re-read of the key directory.
+const reloadInterval = 10 * time.Second
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// SigningKey is a private key used to sign JWTs, identified by its kid.
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	CreatedAt time.Time
+	// RetiredAt is zero while the key is the active signing key.
+	RetiredAt time.Time
+
+	private crypto.Signer
+}
+
+// GenerateSigningKey creates a new random key for the given algorithm.
+func GenerateSigningKey(alg string) (*SigningKey, error) {
+	var signer crypto.Signer
+	switch alg {
+	case AlgRS256:
+		key, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+		if err != nil {
+			return nil, err
+		}
+		signer = key
+	case AlgEdDSA:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		signer = key
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+
+	kid := make([]byte, 8)
+	if _, err := rand.Read(kid); err != nil {
+		return nil, err
+	}
+
+	return &SigningKey{
+		ID:        hex.EncodeToString(kid),
+		Algorithm: alg,
+		CreatedAt: time.Now().UTC(),
+		private:   signer,
+	}, nil
+}
+
+// Public returns the public half of the key.
+func (k *SigningKey) Public() crypto.PublicKey {
+	return k.private.Public()
+}
+
+func (k *SigningKey) method() jwt.SigningMethod {
+	if k.Algorithm == AlgRS256 {
+		return jwt.SigningMethodRS256
+	}
+	return jwt.SigningMethodEdDSA
+}
+
+// JWK returns the public key in JSON Web Key form.
+func (k *SigningKey) JWK() JWK {
+	jwk := JWK{
+		Kid: k.ID,
+		Use: "sig",
+		Alg: k.Algorithm,
+	}
+	switch pub := k.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+	}
+	return jwk
+}
+
+// JWK is a public key as described in RFC 7517.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKSet is the document served at /.well-known/jwks.json.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// KeyRing holds the active signing key plus retired keys that are still
+// accepted for verification until the tokens they signed have expired.
+type KeyRing struct {
+	mu         sync.RWMutex
+	alg        string
+	dir        string
+	verifyFor  time.Duration
+	keys       []*SigningKey // newest first; keys[0] signs new tokens
+	lastReload time.Time
+}
+
+// NewKeyRing creates a key ring for alg. When dir is not empty keys are
+// persisted there as PEM files so they survive restarts and can be shared
+// between instances. verifyFor is how long a retired key keeps verifying
+// tokens and should be at least the longest token lifetime.
+func NewKeyRing(alg, dir string, verifyFor time.Duration) (*KeyRing, error) {
+	if alg == "" {
+		alg = AlgEdDSA
+	}
+	if alg != AlgRS256 && alg != AlgEdDSA {
+		return nil, fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+
+	kr := &KeyRing{
+		alg:       alg,
+		dir:       dir,
+		verifyFor: verifyFor,
+	}
+
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, err
+		}
+		if err := kr.Reload(); err != nil {
+			return nil, err
+		}
+	}
+
+	if active := kr.active(); active == nil || active.Algorithm != alg {
+		if err := kr.Rotate(time.Now()); err != nil {
+			return nil, err
+		}
+	}
+
+	return kr, nil
+}
+
+// Rotate generates a new active key and retires the previous one.
+func (kr *KeyRing) Rotate(now time.Time) error {
+	key, err := GenerateSigningKey(kr.alg)
+	if err != nil {
+		return err
+	}
+	key.CreatedAt = now.UTC()
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	if len(kr.keys) > 0 && kr.keys[0].RetiredAt.IsZero() {
+		kr.keys[0].RetiredAt = now.UTC()
+		if err := kr.save(kr.keys[0]); err != nil {
+			return err
+		}
+	}
+	if err := kr.save(key); err != nil {
+		return err
+	}
+	kr.keys = append([]*SigningKey{key}, kr.keys...)
+	return nil
+}
+
+// RotateIfDue rotates when the active key is older than every.
+func (kr *KeyRing) RotateIfDue(now time.Time, every time.Duration) (bool, error) {
+	active := kr.active()
+	if active != nil && now.Sub(active.CreatedAt) < every {
+		return false, nil
+	}
+	return true, kr.Rotate(now)
+}
+
+// Prune drops retired keys whose tokens can no longer be valid.
+func (kr *KeyRing) Prune(now time.Time) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	kept := kr.keys[:0]
+	for _, key := range kr.keys {
+		if kr.verifies(key, now) {
+			kept = append(kept, key)
+			continue
+		}
+		if kr.dir != "" {
+			err := os.Remove(kr.keyPath(key.ID))
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	kr.keys = kept
+	return nil
+}
+
+// Reload re-reads the key directory, picking up keys rotated by other instances.
+func (kr *KeyRing) Reload() error {
+	if kr.dir == "" {
+		return nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(kr.dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+
+	keys := make([]*SigningKey, 0, len(paths))
+	for _, path := range paths {
+		key, err := loadSigningKey(path)
+		if err != nil {
+			return fmt.Errorf("loading %s: %w", path, err)
+		}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.After(keys[j].CreatedAt)
+	})
+	// Two instances may rotate at the same time; only the newest key stays active.
+	for i := 1; i < len(keys); i++ {
+		if keys[i].RetiredAt.IsZero() {
+			keys[i].RetiredAt = keys[i-1].CreatedAt
+		}
+	}
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	kr.keys = keys
+	kr.lastReload = time.Now()
+	return nil
+}
+
+// JWKS returns the public keys that are currently accepted for verification.
+func (kr *KeyRing) JWKS() JWKSet {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	set := JWKSet{Keys: []JWK{}}
+	now := time.Now()
+	for _, key := range kr.keys {
+		if kr.verifies(key, now) {
+			set.Keys = append(set.Keys, key.JWK())
+		}
+	}
+	return set
+}
+
+func (kr *KeyRing) active() *SigningKey {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	if len(kr.keys) == 0 {
+		return nil
+	}
+	return kr.keys[0]
+}
+
+// lookup finds a verification key by kid, reloading from disk once in a
+// while so keys rotated by another instance are picked up.
+func (kr *KeyRing) lookup(kid string) (*SigningKey, error) {
+	if key := kr.find(kid); key != nil {
+		return key, nil
+	}
+
+	kr.mu.RLock()
+	stale := kr.dir != "" && time.Since(kr.lastReload) > reloadInterval
+	kr.mu.RUnlock()
+	if stale {
+		if err := kr.Reload(); err != nil {
+			return nil, err
+		}
+		if key := kr.find(kid); key != nil {
+			return key, nil
+		}
+	}
+
+	return nil, ErrUnknownKey
+}
+
+func (kr *KeyRing) find(kid string) *SigningKey {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	now := time.Now()
+	for _, key := range kr.keys {
+		if key.ID == kid && kr.verifies(key, now) {
+			return key
+		}
+	}
+	return nil
+}
+
+func (kr *KeyRing) verifies(key *SigningKey, now time.Time) bool {
+	return key.RetiredAt.IsZero() || now.Before(key.RetiredAt.Add(kr.verifyFor))
+}
+
+func (kr *KeyRing) keyPath(kid string) string {
+	return filepath.Join(kr.dir, kid+".pem")
+}
+
+func (kr *KeyRing) save(key *SigningKey) error {
+	if kr.dir == "" {
+		return nil
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key.private)
+	if err != nil {
+		return err
+	}
+
+	headers := map[string]string{
+		"Kid":        key.ID,
+		"Algorithm":  key.Algorithm,
+		"Created-At": key.CreatedAt.Format(time.RFC3339Nano),
+	}
+	if !key.RetiredAt.IsZero() {
+		headers["Retired-At"] = key.RetiredAt.Format(time.RFC3339Nano)
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{
+		Type:    "PRIVATE KEY",
+		Headers: headers,
+		Bytes:   der,
+	})
+
+	// Write to a temporary file first so other instances never read a partial key.
+	tmp := kr.keyPath(key.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, kr.keyPath(key.ID))
+}
+
+func loadSigningKey(path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, errors.New("no PRIVATE KEY block found")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("key cannot sign")
+	}
+
+	key := &SigningKey{
+		ID:        block.Headers["Kid"],
+		Algorithm: block.Headers["Algorithm"],
+		private:   signer,
+	}
+	if key.ID == "" {
+		key.ID = strings.TrimSuffix(filepath.Base(path), ".pem")
+	}
+
+	switch signer.(type) {
+	case *rsa.PrivateKey:
+		if key.Algorithm != AlgRS256 {
+			return nil, fmt.Errorf("RSA key with algorithm %q", key.Algorithm)
+		}
+	case ed25519.PrivateKey:
+		if key.Algorithm != AlgEdDSA {
+			return nil, fmt.Errorf("Ed25519 key with algorithm %q", key.Algorithm)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", signer)
+	}
+
+	key.CreatedAt, err = time.Parse(time.RFC3339Nano, block.Headers["Created-At"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid Created-At: %w", err)
+	}
+	if retired := block.Headers["Retired-At"]; retired != "" {
+		key.RetiredAt, err = time.Parse(time.RFC3339Nano, retired)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Retired-At: %w", err)
+		}
+	}
+
+	return key, nil
+}
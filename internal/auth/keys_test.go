@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestKeyRing(t *testing.T, alg string) *KeyRing {
+	t.Helper()
+	keys, err := NewKeyRing(alg, "", time.Hour)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	return keys
+}
+
+// TestMakeJWTRS256 ensures RSA keys sign and verify tokens.
+func TestMakeJWTRS256(t *testing.T) {
+	keys := newTestKeyRing(t, AlgRS256)
+	userID := uuid.New()
+
+	token, err := MakeJWT(userID, RoleUser, keys, time.Minute)
+	assert.NoError(t, err)
+
+	parsedUserID, err := ValidateJWT(token, keys)
+	assert.NoError(t, err)
+	assert.Equal(t, userID, parsedUserID)
+}
+
+// TestValidateJWTRejectsHS256 ensures tokens signed with a shared secret are no longer accepted.
+func TestValidateJWTRejectsHS256(t *testing.T) {
+	keys := newTestKeyRing(t, AlgEdDSA)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{Subject: uuid.NewString()})
+	token.Header["kid"] = keys.active().ID
+	signed, err := token.SignedString([]byte("supersecret"))
+	assert.NoError(t, err)
+
+	_, err = ValidateJWT(signed, keys)
+	assert.Error(t, err)
+}
+
+// TestKeyRotation ensures tokens signed by a retired key stay valid until the key is pruned.
+func TestKeyRotation(t *testing.T) {
+	keys := newTestKeyRing(t, AlgEdDSA)
+	userID := uuid.New()
+
+	oldToken, err := MakeJWT(userID, RoleUser, keys, time.Minute)
+	assert.NoError(t, err)
+	oldKid := keys.active().ID
+
+	now := time.Now()
+	assert.NoError(t, keys.Rotate(now))
+	assert.NotEqual(t, oldKid, keys.active().ID)
+	assert.Len(t, keys.JWKS().Keys, 2)
+
+	_, err = ValidateJWT(oldToken, keys)
+	assert.NoError(t, err)
+
+	assert.NoError(t, keys.Prune(now.Add(2*time.Hour)))
+	assert.Len(t, keys.JWKS().Keys, 1)
+
+	_, err = ValidateJWT(oldToken, keys)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+// TestRotateIfDue ensures rotation only happens once the active key is old enough.
+func TestRotateIfDue(t *testing.T) {
+	keys := newTestKeyRing(t, AlgEdDSA)
+	kid := keys.active().ID
+
+	rotated, err := keys.RotateIfDue(time.Now(), time.Hour)
+	assert.NoError(t, err)
+	assert.False(t, rotated)
+	assert.Equal(t, kid, keys.active().ID)
+
+	rotated, err = keys.RotateIfDue(time.Now().Add(2*time.Hour), time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, rotated)
+	assert.NotEqual(t, kid, keys.active().ID)
+}
+
+// TestKeyRingPersistence ensures a second key ring sharing the directory verifies the same tokens.
+func TestKeyRingPersistence(t *testing.T) {
+	dir := t.TempDir()
+	first, err := NewKeyRing(AlgRS256, dir, time.Hour)
+	assert.NoError(t, err)
+
+	token, err := MakeJWT(uuid.New(), RoleUser, first, time.Minute)
+	assert.NoError(t, err)
+
+	second, err := NewKeyRing(AlgRS256, dir, time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, first.active().ID, second.active().ID)
+
+	_, err = ValidateJWT(token, second)
+	assert.NoError(t, err)
+
+	// A key rotated by the first instance is picked up by the second on demand.
+	assert.NoError(t, first.Rotate(time.Now()))
+	token, err = MakeJWT(uuid.New(), RoleUser, first, time.Minute)
+	assert.NoError(t, err)
+
+	second.lastReload = time.Time{}
+	_, err = ValidateJWT(token, second)
+	assert.NoError(t, err)
+}
+
+// TestJWKS ensures the published keys carry the fields verifiers need.
+func TestJWKS(t *testing.T) {
+	rsaKeys := newTestKeyRing(t, AlgRS256)
+	jwk := rsaKeys.JWKS().Keys[0]
+	assert.Equal(t, "RSA", jwk.Kty)
+	assert.Equal(t, AlgRS256, jwk.Alg)
+	assert.Equal(t, "AQAB", jwk.E)
+	assert.NotEmpty(t, jwk.N)
+
+	edKeys := newTestKeyRing(t, AlgEdDSA)
+	jwk = edKeys.JWKS().Keys[0]
+	assert.Equal(t, "OKP", jwk.Kty)
+	assert.Equal(t, "Ed25519", jwk.Crv)
+	assert.NotEmpty(t, jwk.X)
+}
@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestVerifyPKCE uses the example from RFC 7636 appendix B.
+func TestVerifyPKCE(t *testing.T) {
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	assert.True(t, VerifyPKCE(verifier, challenge))
+	assert.False(t, VerifyPKCE(verifier+"x", challenge))
+	assert.False(t, VerifyPKCE("short", challenge))
+	assert.False(t, VerifyPKCE(strings.Repeat("a", 42)+"!", challenge))
+}
+
+// TestMakeScopedJWT ensures OAuth tokens carry scopes and the client but no role.
+func TestMakeScopedJWT(t *testing.T) {
+	keys := newTestKeyRing(t, AlgEdDSA)
+	userID := uuid.New()
+	clientID := uuid.New()
+
+	token, err := MakeScopedJWT(userID, clientID, []string{ScopeChirpsRead, ScopeChirpsWrite}, keys, time.Minute)
+	assert.NoError(t, err)
+
+	claims, err := ValidateJWTClaims(token, keys)
+	assert.NoError(t, err)
+	assert.Equal(t, "chirps:read chirps:write", claims.Scope)
+	assert.Equal(t, clientID.String(), claims.ClientID)
+	assert.Empty(t, claims.Role)
+	assert.Equal(t, []string{ScopeChirpsRead, ScopeChirpsWrite}, claims.Scopes())
+}
+
+// TestClaimsScopes ensures first-party tokens are unscoped while OAuth tokens without scopes grant nothing.
+func TestClaimsScopes(t *testing.T) {
+	assert.Nil(t, (&Claims{Role: RoleUser}).Scopes())
+
+	scopes := (&Claims{ClientID: uuid.NewString()}).Scopes()
+	assert.NotNil(t, scopes)
+	assert.Empty(t, scopes)
+}
@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Passwords are hashed with argon2id and stored in the PHC string format,
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>, so each hash records the
+// parameters it was made with. Hashes made before argon2id was introduced
+// are bcrypt hashes; they are still accepted and are upgraded at login.
+
+var (
+	ErrPasswordMismatch    = errors.New("password does not match")
+	ErrUnknownPasswordHash = errors.New("unknown password hash format")
+)
+
+// Argon2Params are the argon2id cost parameters.
+type Argon2Params struct {
+	// Memory is in KiB.
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params follow the second recommended option of RFC 9106 for
+// memory constrained environments, with a larger iteration count.
+var DefaultArgon2Params = Argon2Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// passwordParams are used for new hashes. Hashes made with other parameters
+// still verify but are reported by NeedsRehash.
+var passwordParams = DefaultArgon2Params
+
+// SetArgon2Params changes the parameters used for new hashes. It must be
+// called before any passwords are hashed.
+func SetArgon2Params(p Argon2Params) error {
+	if p.Memory < 8*uint32(p.Parallelism) || p.Iterations < 1 || p.Parallelism < 1 {
+		return fmt.Errorf("invalid argon2 parameters m=%d,t=%d,p=%d", p.Memory, p.Iterations, p.Parallelism)
+	}
+	if p.SaltLength < 16 || p.KeyLength < 16 {
+		return errors.New("argon2 salt and key must be at least 16 bytes")
+	}
+	passwordParams = p
+	return nil
+}
+
+// ParseArgon2Params parses cost parameters written as in a PHC string, such
+// as "m=65536,t=3,p=2". Parameters that are left out keep their defaults.
+func ParseArgon2Params(s string) (Argon2Params, error) {
+	p := DefaultArgon2Params
+	for _, field := range strings.Split(s, ",") {
+		var err error
+		switch {
+		case strings.HasPrefix(field, "m="):
+			_, err = fmt.Sscanf(field, "m=%d", &p.Memory)
+		case strings.HasPrefix(field, "t="):
+			_, err = fmt.Sscanf(field, "t=%d", &p.Iterations)
+		case strings.HasPrefix(field, "p="):
+			_, err = fmt.Sscanf(field, "p=%d", &p.Parallelism)
+		default:
+			err = errors.New("unknown parameter")
+		}
+		if err != nil {
+			return Argon2Params{}, fmt.Errorf("invalid argon2 parameter %q: %w", field, err)
+		}
+	}
+	return p, nil
+}
+
+// HashPassword hashes a password with argon2id and the current parameters.
+func HashPassword(password string) (string, error) {
+	p := passwordParams
+
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// CheckPasswordHash returns nil if password matches an argon2id or bcrypt
+// hash and ErrPasswordMismatch if it does not.
+func CheckPasswordHash(password, hash string) error {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		p, salt, key, err := decodeArgon2Hash(hash)
+		if err != nil {
+			return err
+		}
+		other := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+		if subtle.ConstantTimeCompare(key, other) != 1 {
+			return ErrPasswordMismatch
+		}
+		return nil
+	}
+
+	if isBcryptHash(hash) {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrPasswordMismatch
+		}
+		return err
+	}
+
+	return ErrUnknownPasswordHash
+}
+
+// dummyHash is checked in place of a user's hash when there is no user, so
+// that case takes as long as a wrong password.
+var dummyHash = sync.OnceValues(func() (string, error) {
+	return HashPassword("no such user")
+})
+
+// CheckDummyPassword takes as long as checking password against a hash made
+// by HashPassword and always returns ErrPasswordMismatch. Call it when
+// there is no hash to check, such as for an unknown email, so response
+// times do not tell which accounts exist.
+func CheckDummyPassword(password string) error {
+	hash, err := dummyHash()
+	if err != nil {
+		return err
+	}
+	CheckPasswordHash(password, hash)
+	return ErrPasswordMismatch
+}
+
+// NeedsRehash reports whether hash was made with another algorithm or other
+// parameters than HashPassword currently uses. Call it after a successful
+// CheckPasswordHash and store a new hash of the password if it is true.
+func NeedsRehash(hash string) bool {
+	if !strings.HasPrefix(hash, "$argon2id$") {
+		return true
+	}
+	p, _, _, err := decodeArgon2Hash(hash)
+	if err != nil {
+		return true
+	}
+	return p != passwordParams
+}
+
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func decodeArgon2Hash(hash string) (Argon2Params, []byte, []byte, error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return Argon2Params{}, nil, nil, ErrUnknownPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Argon2Params{}, nil, nil, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+
+	var p Argon2Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return Argon2Params{}, nil, nil, fmt.Errorf("invalid argon2 parameters: %w", err)
+	}
+	if p.Iterations < 1 || p.Parallelism < 1 {
+		return Argon2Params{}, nil, nil, fmt.Errorf("invalid argon2 parameters %q", parts[3])
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2Params{}, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return Argon2Params{}, nil, nil, err
+	}
+	p.SaltLength = uint32(len(salt))
+	p.KeyLength = uint32(len(key))
+
+	return p, salt, key, nil
+}
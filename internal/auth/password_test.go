@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TestCheckPasswordHashBcrypt ensures hashes from before argon2id still verify and are flagged for upgrade.
+func TestCheckPasswordHashBcrypt(t *testing.T) {
+	legacy, err := bcrypt.GenerateFromPassword([]byte("testPassword"), bcrypt.MinCost)
+	assert.NoError(t, err)
+
+	assert.NoError(t, CheckPasswordHash("testPassword", string(legacy)))
+	assert.ErrorIs(t, CheckPasswordHash("wrongPassword", string(legacy)), ErrPasswordMismatch)
+	assert.True(t, NeedsRehash(string(legacy)))
+}
+
+// TestHashPasswordLong ensures passwords are not truncated at 72 bytes like bcrypt does.
+func TestHashPasswordLong(t *testing.T) {
+	password := strings.Repeat("a", 100)
+	hash, err := HashPassword(password)
+	assert.NoError(t, err)
+
+	assert.NoError(t, CheckPasswordHash(password, hash))
+	assert.ErrorIs(t, CheckPasswordHash(password[:72], hash), ErrPasswordMismatch)
+}
+
+// TestNeedsRehash ensures hashes made with other parameters are flagged but still verify.
+func TestNeedsRehash(t *testing.T) {
+	t.Cleanup(func() { passwordParams = DefaultArgon2Params })
+
+	hash, err := HashPassword("testPassword")
+	assert.NoError(t, err)
+	assert.False(t, NeedsRehash(hash))
+
+	params := DefaultArgon2Params
+	params.Iterations = 4
+	assert.NoError(t, SetArgon2Params(params))
+
+	assert.True(t, NeedsRehash(hash))
+	assert.NoError(t, CheckPasswordHash("testPassword", hash))
+}
+
+func TestCheckPasswordHashUnknownFormat(t *testing.T) {
+	assert.ErrorIs(t, CheckPasswordHash("testPassword", "plaintext"), ErrUnknownPasswordHash)
+	assert.Error(t, CheckPasswordHash("testPassword", "$argon2id$v=19$m=65536,t=0,p=0$c2FsdA$a2V5"))
+}
+
+func TestParseArgon2Params(t *testing.T) {
+	p, err := ParseArgon2Params("m=131072,t=4,p=1")
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(131072), p.Memory)
+	assert.Equal(t, uint32(4), p.Iterations)
+	assert.Equal(t, uint8(1), p.Parallelism)
+	assert.Equal(t, DefaultArgon2Params.KeyLength, p.KeyLength)
+
+	_, err = ParseArgon2Params("m=lots")
+	assert.Error(t, err)
+	_, err = ParseArgon2Params("x=1")
+	assert.Error(t, err)
+}
+
+// TestCheckDummyPassword ensures the stand-in check for unknown users never succeeds.
+func TestCheckDummyPassword(t *testing.T) {
+	assert.ErrorIs(t, CheckDummyPassword("no such user"), ErrPasswordMismatch)
+	assert.ErrorIs(t, CheckDummyPassword(""), ErrPasswordMismatch)
+}
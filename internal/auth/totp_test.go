@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// RFC 6238 appendix B test vectors for SHA1, truncated to six digits.
+var rfc6238Secret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestValidateTOTPVectors(t *testing.T) {
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+
+	for unix, code := range vectors {
+		step, ok := ValidateTOTP(rfc6238Secret, code, time.Unix(unix, 0), 0)
+		assert.True(t, ok, "code %s at %d", code, unix)
+		assert.Equal(t, unix/30, step)
+	}
+}
+
+// TestValidateTOTPReplay ensures a code cannot be used twice.
+func TestValidateTOTPReplay(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+
+	step, ok := ValidateTOTP(rfc6238Secret, "081804", now, 0)
+	assert.True(t, ok)
+
+	_, ok = ValidateTOTP(rfc6238Secret, "081804", now, step)
+	assert.False(t, ok)
+}
+
+// TestValidateTOTPSkew ensures codes from adjacent periods are accepted and older ones are not.
+func TestValidateTOTPSkew(t *testing.T) {
+	_, ok := ValidateTOTP(rfc6238Secret, "081804", time.Unix(1111111109+30, 0), 0)
+	assert.True(t, ok)
+
+	_, ok = ValidateTOTP(rfc6238Secret, "081804", time.Unix(1111111109+90, 0), 0)
+	assert.False(t, ok)
+}
+
+func TestTOTPURI(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	assert.NoError(t, err)
+
+	uri := TOTPURI("Chirpy", "user@example.com", secret)
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/Chirpy:user@example.com?"))
+	assert.Contains(t, uri, "secret="+secret)
+	assert.Contains(t, uri, "issuer=Chirpy")
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	assert.NoError(t, err)
+	assert.Len(t, codes, 10)
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		assert.Len(t, code, 11)
+		assert.False(t, seen[code])
+		seen[code] = true
+		assert.Equal(t, strings.ReplaceAll(code, "-", ""), NormalizeRecoveryCode(strings.ToUpper(code)))
+	}
+}
+
+func TestSealSecret(t *testing.T) {
+	sealed, err := SealSecret("JBSWY3DPEHPK3PXP", "pepper")
+	assert.NoError(t, err)
+	assert.NotContains(t, sealed, "JBSWY3DPEHPK3PXP")
+
+	opened, err := OpenSecret(sealed, "pepper")
+	assert.NoError(t, err)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", opened)
+
+	_, err = OpenSecret(sealed, "other pepper")
+	assert.Error(t, err)
+}
@@ -0,0 +1,39 @@
+// Package blobstore keeps uploaded files, such as images, in a local
+// directory or an S3-compatible object store.
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"regexp"
+)
+
+var (
+	ErrNotFound   = errors.New("blobstore: blob not found")
+	ErrInvalidKey = errors.New("blobstore: invalid key")
+)
+
+// keyPattern is what a key may look like: slash separated segments of
+// lower case letters, digits, dots, dashes and underscores that do not
+// start with a dot.
+var keyPattern = regexp.MustCompile(`^[a-z0-9_-][a-z0-9._-]*(/[a-z0-9_-][a-z0-9._-]*)*$`)
+
+// ValidKey reports whether key can name a blob. Keys are restricted so
+// they are safe as file paths and URL paths alike.
+func ValidKey(key string) bool {
+	return len(key) <= 256 && keyPattern.MatchString(key)
+}
+
+// BlobStore stores blobs by key. Blobs are written once and never changed,
+// so callers use a new key for new content.
+type BlobStore interface {
+	// Put stores data under key, replacing any blob already there.
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// Get opens the blob under key and returns its content type. It
+	// returns ErrNotFound if there is none. The caller closes the reader.
+	Get(ctx context.Context, key string) (io.ReadCloser, string, error)
+	// Delete removes the blob under key. Deleting a missing blob is not an
+	// error.
+	Delete(ctx context.Context, key string) error
+}
@@ -7,23 +7,86 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
+const countChirpsByAuthor = `-- name: CountChirpsByAuthor :one
+SELECT COUNT(*) FROM chirps WHERE user_id = $1 AND deleted_at IS NULL
+`
+
+// Rechirps count too; tombstones do not.
+func (q *Queries) CountChirpsByAuthor(ctx context.Context, userID uuid.UUID) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countChirpsByAuthor, userID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const createChirp = `-- name: CreateChirp :one
-INSERT INTO chirps (user_id, body)
-VALUES ($1, $2)
-RETURNING id, created_at, updated_at, user_id, body
+INSERT INTO chirps (user_id, body, in_reply_to, conversation_id, quote_of)
+VALUES (
+    $1,
+    $2,
+    $3,
+    (SELECT parent.conversation_id FROM chirps parent WHERE parent.id = $3),
+    $4
+)
+RETURNING id, created_at, updated_at, user_id, body, search_vector, in_reply_to, conversation_id, reply_count, deleted_at, like_count, rechirp_of, quote_of
 `
 
 type CreateChirpParams struct {
-	UserID uuid.UUID
-	Body   string
+	UserID    uuid.UUID
+	Body      string
+	InReplyTo uuid.NullUUID
+	QuoteOf   uuid.NullUUID
 }
 
+// A reply joins the conversation of the chirp it replies to.
 func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, createChirp, arg.UserID, arg.Body)
+	row := q.db.QueryRowContext(ctx, createChirp,
+		arg.UserID,
+		arg.Body,
+		arg.InReplyTo,
+		arg.QuoteOf,
+	)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.Body,
+		&i.SearchVector,
+		&i.InReplyTo,
+		&i.ConversationID,
+		&i.ReplyCount,
+		&i.DeletedAt,
+		&i.LikeCount,
+		&i.RechirpOf,
+		&i.QuoteOf,
+	)
+	return i, err
+}
+
+const createRechirp = `-- name: CreateRechirp :one
+INSERT INTO chirps (user_id, body, rechirp_of)
+VALUES ($1, '', $2)
+ON CONFLICT (rechirp_of, user_id) WHERE rechirp_of IS NOT NULL DO NOTHING
+RETURNING id, created_at, updated_at, user_id, body, search_vector, in_reply_to, conversation_id, reply_count, deleted_at, like_count, rechirp_of, quote_of
+`
+
+type CreateRechirpParams struct {
+	UserID    uuid.UUID
+	RechirpOf uuid.NullUUID
+}
+
+// Returns no rows if the user has already rechirped the chirp.
+func (q *Queries) CreateRechirp(ctx context.Context, arg CreateRechirpParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, createRechirp, arg.UserID, arg.RechirpOf)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -31,10 +94,27 @@ func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp
 		&i.UpdatedAt,
 		&i.UserID,
 		&i.Body,
+		&i.SearchVector,
+		&i.InReplyTo,
+		&i.ConversationID,
+		&i.ReplyCount,
+		&i.DeletedAt,
+		&i.LikeCount,
+		&i.RechirpOf,
+		&i.QuoteOf,
 	)
 	return i, err
 }
 
+const decrementReplyCount = `-- name: DecrementReplyCount :exec
+UPDATE chirps SET reply_count = reply_count - 1 WHERE id = $1 AND reply_count > 0
+`
+
+func (q *Queries) DecrementReplyCount(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, decrementReplyCount, id)
+	return err
+}
+
 const deleteChirp = `-- name: DeleteChirp :exec
 DELETE FROM chirps WHERE id = $1
 `
@@ -44,8 +124,67 @@ func (q *Queries) DeleteChirp(ctx context.Context, id uuid.UUID) error {
 	return err
 }
 
+const deleteRechirp = `-- name: DeleteRechirp :execrows
+DELETE FROM chirps WHERE user_id = $1 AND rechirp_of = $2
+`
+
+type DeleteRechirpParams struct {
+	UserID    uuid.UUID
+	RechirpOf uuid.NullUUID
+}
+
+func (q *Queries) DeleteRechirp(ctx context.Context, arg DeleteRechirpParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteRechirp, arg.UserID, arg.RechirpOf)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteRechirpsOf = `-- name: DeleteRechirpsOf :many
+DELETE FROM chirps WHERE rechirp_of = $1
+RETURNING id, created_at, updated_at, user_id, body, search_vector, in_reply_to, conversation_id, reply_count, deleted_at, like_count, rechirp_of, quote_of
+`
+
+func (q *Queries) DeleteRechirpsOf(ctx context.Context, rechirpOf uuid.NullUUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, deleteRechirpsOf, rechirpOf)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.UserID,
+			&i.Body,
+			&i.SearchVector,
+			&i.InReplyTo,
+			&i.ConversationID,
+			&i.ReplyCount,
+			&i.DeletedAt,
+			&i.LikeCount,
+			&i.RechirpOf,
+			&i.QuoteOf,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getChirp = `-- name: GetChirp :one
-SELECT id, created_at, updated_at, user_id, body FROM chirps WHERE id = $1
+SELECT id, created_at, updated_at, user_id, body, search_vector, in_reply_to, conversation_id, reply_count, deleted_at, like_count, rechirp_of, quote_of FROM chirps WHERE id = $1
 `
 
 func (q *Queries) GetChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
@@ -57,16 +196,159 @@ func (q *Queries) GetChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
 		&i.UpdatedAt,
 		&i.UserID,
 		&i.Body,
+		&i.SearchVector,
+		&i.InReplyTo,
+		&i.ConversationID,
+		&i.ReplyCount,
+		&i.DeletedAt,
+		&i.LikeCount,
+		&i.RechirpOf,
+		&i.QuoteOf,
 	)
 	return i, err
 }
 
-const getChirps = `-- name: GetChirps :many
-SELECT id, created_at, updated_at, user_id, body FROM chirps ORDER BY created_at ASC
+const getChirpForUpdate = `-- name: GetChirpForUpdate :one
+SELECT id, created_at, updated_at, user_id, body, search_vector, in_reply_to, conversation_id, reply_count, deleted_at, like_count, rechirp_of, quote_of FROM chirps WHERE id = $1 FOR UPDATE
+`
+
+// Locks the chirp until the transaction ends, so concurrent edits cannot
+// lose a revision.
+func (q *Queries) GetChirpForUpdate(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, getChirpForUpdate, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.Body,
+		&i.SearchVector,
+		&i.InReplyTo,
+		&i.ConversationID,
+		&i.ReplyCount,
+		&i.DeletedAt,
+		&i.LikeCount,
+		&i.RechirpOf,
+		&i.QuoteOf,
+	)
+	return i, err
+}
+
+const getChirpsByIDs = `-- name: GetChirpsByIDs :many
+SELECT id, created_at, updated_at, user_id, body, search_vector, in_reply_to, conversation_id, reply_count, deleted_at, like_count, rechirp_of, quote_of FROM chirps
+WHERE id = ANY($1::uuid[])
+AND NOT chirp_hidden_from($2::uuid, user_id, body, rechirp_of)
+`
+
+type GetChirpsByIDsParams struct {
+	Ids      []uuid.UUID
+	ViewerID uuid.NullUUID
+}
+
+// Chirps hidden from viewer_id are left out.
+func (q *Queries) GetChirpsByIDs(ctx context.Context, arg GetChirpsByIDsParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpsByIDs, pq.Array(arg.Ids), arg.ViewerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.UserID,
+			&i.Body,
+			&i.SearchVector,
+			&i.InReplyTo,
+			&i.ConversationID,
+			&i.ReplyCount,
+			&i.DeletedAt,
+			&i.LikeCount,
+			&i.RechirpOf,
+			&i.QuoteOf,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRechirp = `-- name: GetRechirp :one
+SELECT id, created_at, updated_at, user_id, body, search_vector, in_reply_to, conversation_id, reply_count, deleted_at, like_count, rechirp_of, quote_of FROM chirps WHERE user_id = $1 AND rechirp_of = $2
+`
+
+type GetRechirpParams struct {
+	UserID    uuid.UUID
+	RechirpOf uuid.NullUUID
+}
+
+func (q *Queries) GetRechirp(ctx context.Context, arg GetRechirpParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, getRechirp, arg.UserID, arg.RechirpOf)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.Body,
+		&i.SearchVector,
+		&i.InReplyTo,
+		&i.ConversationID,
+		&i.ReplyCount,
+		&i.DeletedAt,
+		&i.LikeCount,
+		&i.RechirpOf,
+		&i.QuoteOf,
+	)
+	return i, err
+}
+
+const incrementReplyCount = `-- name: IncrementReplyCount :exec
+UPDATE chirps SET reply_count = reply_count + 1 WHERE id = $1
+`
+
+func (q *Queries) IncrementReplyCount(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, incrementReplyCount, id)
+	return err
+}
+
+const listChirpAncestors = `-- name: ListChirpAncestors :many
+WITH RECURSIVE ancestors AS (
+    SELECT parent.id, parent.in_reply_to, 1 AS depth
+    FROM chirps child
+    JOIN chirps parent ON parent.id = child.in_reply_to
+    WHERE child.id = $1
+    UNION ALL
+    SELECT chirps.id, chirps.in_reply_to, ancestors.depth + 1
+    FROM chirps
+    JOIN ancestors ON chirps.id = ancestors.in_reply_to
+)
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.user_id, chirps.body, chirps.search_vector, chirps.in_reply_to, chirps.conversation_id, chirps.reply_count, chirps.deleted_at, chirps.like_count, chirps.rechirp_of, chirps.quote_of FROM chirps
+JOIN ancestors ON chirps.id = ancestors.id
+WHERE NOT chirp_hidden_from($2::uuid, chirps.user_id, chirps.body, chirps.rechirp_of)
+ORDER BY ancestors.depth DESC
 `
 
-func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getChirps)
+type ListChirpAncestorsParams struct {
+	ID       uuid.UUID
+	ViewerID uuid.NullUUID
+}
+
+// The chirps a chirp replies to, up to the start of the conversation,
+// root first. Chirps hidden from viewer_id are left out.
+func (q *Queries) ListChirpAncestors(ctx context.Context, arg ListChirpAncestorsParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpAncestors, arg.ID, arg.ViewerID)
 	if err != nil {
 		return nil, err
 	}
@@ -80,6 +362,14 @@ func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
 			&i.UpdatedAt,
 			&i.UserID,
 			&i.Body,
+			&i.SearchVector,
+			&i.InReplyTo,
+			&i.ConversationID,
+			&i.ReplyCount,
+			&i.DeletedAt,
+			&i.LikeCount,
+			&i.RechirpOf,
+			&i.QuoteOf,
 		); err != nil {
 			return nil, err
 		}
@@ -94,12 +384,35 @@ func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
 	return items, nil
 }
 
-const getChirpsByAuthor = `-- name: GetChirpsByAuthor :many
-SELECT id, created_at, updated_at, user_id, body FROM chirps WHERE user_id = $1 ORDER BY created_at ASC
+const listChirpsAsc = `-- name: ListChirpsAsc :many
+SELECT id, created_at, updated_at, user_id, body, search_vector, in_reply_to, conversation_id, reply_count, deleted_at, like_count, rechirp_of, quote_of FROM chirps
+WHERE deleted_at IS NULL
+AND ($1::uuid IS NULL OR user_id = $1)
+AND ($2::timestamptz IS NULL OR (created_at, id) > ($2, $3::uuid))
+AND NOT chirp_hidden_from($4::uuid, user_id, body, rechirp_of)
+ORDER BY created_at ASC, id ASC
+LIMIT $5
 `
 
-func (q *Queries) GetChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getChirpsByAuthor, userID)
+type ListChirpsAscParams struct {
+	AuthorID       uuid.NullUUID
+	AfterCreatedAt sql.NullTime
+	AfterID        uuid.NullUUID
+	ViewerID       uuid.NullUUID
+	MaxRows        sql.NullInt32
+}
+
+// Chirps oldest first, optionally by one author. Pass the created_at and id
+// of the last chirp of a page to get the next one. A NULL max_rows returns
+// every chirp. Chirps hidden from viewer_id are left out.
+func (q *Queries) ListChirpsAsc(ctx context.Context, arg ListChirpsAscParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpsAsc,
+		arg.AuthorID,
+		arg.AfterCreatedAt,
+		arg.AfterID,
+		arg.ViewerID,
+		arg.MaxRows,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -113,6 +426,14 @@ func (q *Queries) GetChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Ch
 			&i.UpdatedAt,
 			&i.UserID,
 			&i.Body,
+			&i.SearchVector,
+			&i.InReplyTo,
+			&i.ConversationID,
+			&i.ReplyCount,
+			&i.DeletedAt,
+			&i.LikeCount,
+			&i.RechirpOf,
+			&i.QuoteOf,
 		); err != nil {
 			return nil, err
 		}
@@ -126,3 +447,290 @@ func (q *Queries) GetChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Ch
 	}
 	return items, nil
 }
+
+const listChirpsDesc = `-- name: ListChirpsDesc :many
+SELECT id, created_at, updated_at, user_id, body, search_vector, in_reply_to, conversation_id, reply_count, deleted_at, like_count, rechirp_of, quote_of FROM chirps
+WHERE deleted_at IS NULL
+AND ($1::uuid IS NULL OR user_id = $1)
+AND ($2::timestamptz IS NULL OR (created_at, id) < ($2, $3::uuid))
+AND NOT chirp_hidden_from($4::uuid, user_id, body, rechirp_of)
+ORDER BY created_at DESC, id DESC
+LIMIT $5
+`
+
+type ListChirpsDescParams struct {
+	AuthorID        uuid.NullUUID
+	BeforeCreatedAt sql.NullTime
+	BeforeID        uuid.NullUUID
+	ViewerID        uuid.NullUUID
+	MaxRows         sql.NullInt32
+}
+
+// Like ListChirpsAsc, newest first.
+func (q *Queries) ListChirpsDesc(ctx context.Context, arg ListChirpsDescParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpsDesc,
+		arg.AuthorID,
+		arg.BeforeCreatedAt,
+		arg.BeforeID,
+		arg.ViewerID,
+		arg.MaxRows,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.UserID,
+			&i.Body,
+			&i.SearchVector,
+			&i.InReplyTo,
+			&i.ConversationID,
+			&i.ReplyCount,
+			&i.DeletedAt,
+			&i.LikeCount,
+			&i.RechirpOf,
+			&i.QuoteOf,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listReplyTree = `-- name: ListReplyTree :many
+WITH RECURSIVE tree AS (
+    (
+        SELECT chirps.id, 1 AS depth
+        FROM chirps
+        WHERE chirps.in_reply_to = $1::uuid
+        AND ($2::timestamptz IS NULL OR (chirps.created_at, chirps.id) > ($2, $3::uuid))
+        AND NOT chirp_hidden_from($4::uuid, chirps.user_id, chirps.body, chirps.rechirp_of)
+        ORDER BY chirps.created_at ASC, chirps.id ASC
+        LIMIT $5
+    )
+    UNION ALL
+    SELECT child.id, tree.depth + 1
+    FROM tree
+    CROSS JOIN LATERAL (
+        SELECT chirps.id FROM chirps
+        WHERE chirps.in_reply_to = tree.id
+        AND NOT chirp_hidden_from($4::uuid, chirps.user_id, chirps.body, chirps.rechirp_of)
+        ORDER BY chirps.created_at ASC, chirps.id ASC
+        LIMIT $6
+    ) child
+    WHERE tree.depth < $7::int
+)
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.user_id, chirps.body, chirps.search_vector, chirps.in_reply_to, chirps.conversation_id, chirps.reply_count, chirps.deleted_at, chirps.like_count, chirps.rechirp_of, chirps.quote_of FROM chirps
+JOIN tree ON chirps.id = tree.id
+ORDER BY chirps.created_at ASC, chirps.id ASC
+`
+
+type ListReplyTreeParams struct {
+	ParentID       uuid.UUID
+	AfterCreatedAt sql.NullTime
+	AfterID        uuid.NullUUID
+	ViewerID       uuid.NullUUID
+	MaxRows        int32
+	MaxChildren    int32
+	MaxDepth       int32
+}
+
+// A page of the direct replies to a chirp, oldest first, with their own
+// replies down to max_depth levels. Below the first level at most
+// max_children replies are returned per chirp. Pass the created_at and id
+// of the last direct reply of a page to get the next one. Replies hidden
+// from viewer_id are left out, along with the replies to them.
+func (q *Queries) ListReplyTree(ctx context.Context, arg ListReplyTreeParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listReplyTree,
+		arg.ParentID,
+		arg.AfterCreatedAt,
+		arg.AfterID,
+		arg.ViewerID,
+		arg.MaxRows,
+		arg.MaxChildren,
+		arg.MaxDepth,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.UserID,
+			&i.Body,
+			&i.SearchVector,
+			&i.InReplyTo,
+			&i.ConversationID,
+			&i.ReplyCount,
+			&i.DeletedAt,
+			&i.LikeCount,
+			&i.RechirpOf,
+			&i.QuoteOf,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const searchChirps = `-- name: SearchChirps :many
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.user_id, chirps.body,
+    chirps.in_reply_to, chirps.conversation_id, chirps.reply_count, chirps.like_count, chirps.quote_of,
+    ts_rank_cd(chirps.search_vector, tsq) AS rank,
+    ts_headline('english', chirps.body, tsq, 'StartSel=' || chr(57344) || ', StopSel=' || chr(57345) || ', MaxFragments=2, MaxWords=20, MinWords=5')::text AS snippet
+FROM chirps, to_tsquery('english', $1) tsq
+WHERE chirps.search_vector @@ tsq
+AND chirps.deleted_at IS NULL
+AND ($2::uuid IS NULL OR chirps.user_id = $2)
+AND ($3::timestamptz IS NULL OR chirps.created_at >= $3)
+AND ($4::timestamptz IS NULL OR chirps.created_at < $4)
+AND ($5::real IS NULL OR (ts_rank_cd(chirps.search_vector, tsq), chirps.id) < ($5, $6::uuid))
+AND NOT chirp_hidden_from($7::uuid, chirps.user_id, chirps.body, chirps.rechirp_of)
+ORDER BY rank DESC, chirps.id DESC
+LIMIT $8
+`
+
+type SearchChirpsParams struct {
+	Query     string
+	AuthorID  uuid.NullUUID
+	Since     sql.NullTime
+	Until     sql.NullTime
+	AfterRank sql.NullFloat64
+	AfterID   uuid.NullUUID
+	ViewerID  uuid.NullUUID
+	MaxRows   int32
+}
+
+type SearchChirpsRow struct {
+	ID             uuid.UUID
+	CreatedAt      time.Time
+	UpdatedAt      sql.NullTime
+	UserID         uuid.UUID
+	Body           string
+	InReplyTo      uuid.NullUUID
+	ConversationID uuid.UUID
+	ReplyCount     int32
+	LikeCount      int32
+	QuoteOf        uuid.NullUUID
+	Rank           float32
+	Snippet        string
+}
+
+// Chirps matching a to_tsquery query, most relevant first, with the
+// matches in snippet wrapped in U+E000 and U+E001. Pass the rank and id of
+// the last result of a page to get the next one. Chirps hidden from
+// viewer_id are left out.
+func (q *Queries) SearchChirps(ctx context.Context, arg SearchChirpsParams) ([]SearchChirpsRow, error) {
+	rows, err := q.db.QueryContext(ctx, searchChirps,
+		arg.Query,
+		arg.AuthorID,
+		arg.Since,
+		arg.Until,
+		arg.AfterRank,
+		arg.AfterID,
+		arg.ViewerID,
+		arg.MaxRows,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchChirpsRow
+	for rows.Next() {
+		var i SearchChirpsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.UserID,
+			&i.Body,
+			&i.InReplyTo,
+			&i.ConversationID,
+			&i.ReplyCount,
+			&i.LikeCount,
+			&i.QuoteOf,
+			&i.Rank,
+			&i.Snippet,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const tombstoneChirp = `-- name: TombstoneChirp :exec
+UPDATE chirps
+SET body = '', deleted_at = NOW()
+WHERE id = $1
+`
+
+// Clears a deleted chirp that has replies, keeping its place in the thread.
+func (q *Queries) TombstoneChirp(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, tombstoneChirp, id)
+	return err
+}
+
+const updateChirpBody = `-- name: UpdateChirpBody :one
+UPDATE chirps
+SET body = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, user_id, body, search_vector, in_reply_to, conversation_id, reply_count, deleted_at, like_count, rechirp_of, quote_of
+`
+
+type UpdateChirpBodyParams struct {
+	ID   uuid.UUID
+	Body string
+}
+
+func (q *Queries) UpdateChirpBody(ctx context.Context, arg UpdateChirpBodyParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, updateChirpBody, arg.ID, arg.Body)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.Body,
+		&i.SearchVector,
+		&i.InReplyTo,
+		&i.ConversationID,
+		&i.ReplyCount,
+		&i.DeletedAt,
+		&i.LikeCount,
+		&i.RechirpOf,
+		&i.QuoteOf,
+	)
+	return i, err
+}
@@ -6,33 +6,224 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+type AuditEvent struct {
+	ID         int64
+	CreatedAt  time.Time
+	ActorID    uuid.NullUUID
+	Action     string
+	TargetType string
+	TargetID   string
+	Ip         string
+	UserAgent  string
+	Metadata   json.RawMessage
+}
+
+type Block struct {
+	BlockerID uuid.UUID
+	BlockedID uuid.UUID
+	CreatedAt time.Time
+}
+
 type Chirp struct {
+	ID             uuid.UUID
+	CreatedAt      time.Time
+	UpdatedAt      sql.NullTime
+	UserID         uuid.UUID
+	Body           string
+	SearchVector   interface{}
+	InReplyTo      uuid.NullUUID
+	ConversationID uuid.UUID
+	ReplyCount     int32
+	DeletedAt      sql.NullTime
+	LikeCount      int32
+	RechirpOf      uuid.NullUUID
+	QuoteOf        uuid.NullUUID
+}
+
+type ChirpAttachment struct {
+	ChirpID  uuid.UUID
+	Position int32
+	MediaID  uuid.UUID
+	AltText  string
+}
+
+type ChirpEvent struct {
+	ID        int64
+	CreatedAt time.Time
+	Type      string
+	ChirpID   uuid.UUID
+	UserID    uuid.UUID
+}
+
+type ChirpLike struct {
+	UserID    uuid.UUID
+	ChirpID   uuid.UUID
+	CreatedAt time.Time
+}
+
+type ChirpRevision struct {
+	ID         uuid.UUID
+	ChirpID    uuid.UUID
+	Body       string
+	CreatedAt  time.Time
+	ReplacedAt time.Time
+}
+
+type EmailVerificationToken struct {
+	TokenHash string
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	Email     string
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+}
+
+type Follow struct {
+	FollowerID uuid.UUID
+	FolloweeID uuid.UUID
+	CreatedAt  time.Time
+}
+
+type LoginAttempt struct {
+	Key               string
+	Failures          int32
+	LastFailureAt     time.Time
+	PreviousFailureAt sql.NullTime
+}
+
+type Media struct {
+	ID           uuid.UUID
+	UserID       uuid.UUID
+	CreatedAt    time.Time
+	ContentType  string
+	Width        int32
+	Height       int32
+	BlobKey      string
+	ThumbnailKey string
+}
+
+type MfaRecoveryCode struct {
 	ID        uuid.UUID
-	CreatedAt sql.NullTime
-	UpdatedAt sql.NullTime
+	CreatedAt time.Time
 	UserID    uuid.UUID
-	Body      string
+	CodeHash  string
+	UsedAt    sql.NullTime
 }
 
-type RefreshToken struct {
-	Token     string
-	CreatedAt sql.NullTime
-	UpdatedAt sql.NullTime
+type Mute struct {
+	MuterID   uuid.UUID
+	MutedID   uuid.UUID
+	CreatedAt time.Time
+}
+
+type MutedWord struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	Word      string
+	CreatedAt time.Time
+}
+
+type OauthAuthorizationCode struct {
+	CodeHash      string
+	CreatedAt     time.Time
+	ClientID      uuid.UUID
+	UserID        uuid.UUID
+	RedirectUri   string
+	Scopes        []string
+	CodeChallenge string
+	FamilyID      uuid.UUID
+	ExpiresAt     time.Time
+	UsedAt        sql.NullTime
+}
+
+type OauthClient struct {
+	ID           uuid.UUID
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Name         string
+	SecretHash   sql.NullString
+	RedirectUris []string
+	Scopes       []string
+}
+
+type PasswordResetToken struct {
+	TokenHash string
+	CreatedAt time.Time
 	UserID    uuid.UUID
 	ExpiresAt time.Time
-	RevokedAt sql.NullTime
+	UsedAt    sql.NullTime
+}
+
+type PersonalAccessToken struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UserID     uuid.UUID
+	Name       string
+	TokenHash  string
+	Scopes     []string
+	ExpiresAt  sql.NullTime
+	LastUsedAt sql.NullTime
+	RevokedAt  sql.NullTime
+}
+
+type RefreshToken struct {
+	TokenHash        string
+	CreatedAt        sql.NullTime
+	UpdatedAt        sql.NullTime
+	UserID           uuid.UUID
+	ExpiresAt        time.Time
+	RevokedAt        sql.NullTime
+	FamilyID         uuid.UUID
+	Hashed           bool
+	UserAgent        string
+	Ip               string
+	SessionStartedAt time.Time
+	LastUsedAt       time.Time
+	ClientID         uuid.NullUUID
+	Scopes           []string
+}
+
+type TimelineEntry struct {
+	UserID    uuid.UUID
+	ChirpID   uuid.UUID
+	AuthorID  uuid.UUID
+	CreatedAt time.Time
 }
 
 type User struct {
-	ID             uuid.UUID
-	CreatedAt      sql.NullTime
-	UpdatedAt      sql.NullTime
-	Email          string
-	HashedPassword string
-	IsRed          bool
+	ID              uuid.UUID
+	CreatedAt       sql.NullTime
+	UpdatedAt       sql.NullTime
+	Email           string
+	HashedPassword  string
+	IsRed           bool
+	EmailVerifiedAt sql.NullTime
+	PendingEmail    sql.NullString
+	TotpSecret      sql.NullString
+	TotpEnabledAt   sql.NullTime
+	TotpLastStep    int64
+	Role            string
+	FollowerCount   int32
+	FollowingCount  int32
+	FanoutOnRead    bool
+	Handle          sql.NullString
+	DisplayName     string
+	Bio             string
+	AvatarUrl       string
+	Website         string
+}
+
+type UserIdentity struct {
+	Issuer      string
+	Subject     string
+	UserID      uuid.UUID
+	Email       string
+	CreatedAt   time.Time
+	LastLoginAt time.Time
 }
@@ -8,7 +8,6 @@ package database
 import (
 	"context"
 	"database/sql"
-	"time"
 
 	"github.com/google/uuid"
 )
@@ -23,20 +22,21 @@ func (q *Queries) ClearUsers(ctx context.Context) error {
 }
 
 const createUser = `-- name: CreateUser :one
-INSERT INTO users (email, hashed_password)
+INSERT INTO users (email, hashed_password, handle)
 VALUES (
-    $1, $2
+    $1, $2, $3
 )
-RETURNING id, created_at, updated_at, email, hashed_password, is_red
+RETURNING id, created_at, updated_at, email, hashed_password, is_red, email_verified_at, pending_email, totp_secret, totp_enabled_at, totp_last_step, role, follower_count, following_count, fanout_on_read, handle, display_name, bio, avatar_url, website
 `
 
 type CreateUserParams struct {
 	Email          string
 	HashedPassword string
+	Handle         sql.NullString
 }
 
 func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
-	row := q.db.QueryRowContext(ctx, createUser, arg.Email, arg.HashedPassword)
+	row := q.db.QueryRowContext(ctx, createUser, arg.Email, arg.HashedPassword, arg.Handle)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -45,12 +45,109 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsRed,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.Role,
+		&i.FollowerCount,
+		&i.FollowingCount,
+		&i.FanoutOnRead,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.Website,
+	)
+	return i, err
+}
+
+const disableTOTP = `-- name: DisableTOTP :exec
+UPDATE users
+SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0, updated_at = NOW()
+WHERE id = $1
+`
+
+func (q *Queries) DisableTOTP(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, disableTOTP, id)
+	return err
+}
+
+const enableTOTP = `-- name: EnableTOTP :one
+UPDATE users
+SET totp_enabled_at = NOW(), totp_last_step = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, is_red, email_verified_at, pending_email, totp_secret, totp_enabled_at, totp_last_step, role, follower_count, following_count, fanout_on_read, handle, display_name, bio, avatar_url, website
+`
+
+type EnableTOTPParams struct {
+	ID           uuid.UUID
+	TotpLastStep int64
+}
+
+func (q *Queries) EnableTOTP(ctx context.Context, arg EnableTOTPParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, enableTOTP, arg.ID, arg.TotpLastStep)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsRed,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.Role,
+		&i.FollowerCount,
+		&i.FollowingCount,
+		&i.FanoutOnRead,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.Website,
+	)
+	return i, err
+}
+
+const getUser = `-- name: GetUser :one
+SELECT id, created_at, updated_at, email, hashed_password, is_red, email_verified_at, pending_email, totp_secret, totp_enabled_at, totp_last_step, role, follower_count, following_count, fanout_on_read, handle, display_name, bio, avatar_url, website FROM users WHERE id = $1
+`
+
+func (q *Queries) GetUser(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUser, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsRed,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.Role,
+		&i.FollowerCount,
+		&i.FollowingCount,
+		&i.FanoutOnRead,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.Website,
 	)
 	return i, err
 }
 
 const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, created_at, updated_at, email, hashed_password, is_red FROM users WHERE email = $1
+SELECT id, created_at, updated_at, email, hashed_password, is_red, email_verified_at, pending_email, totp_secret, totp_enabled_at, totp_last_step, role, follower_count, following_count, fanout_on_read, handle, display_name, bio, avatar_url, website FROM users WHERE email = $1
 `
 
 func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error) {
@@ -63,36 +160,152 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsRed,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.Role,
+		&i.FollowerCount,
+		&i.FollowingCount,
+		&i.FanoutOnRead,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.Website,
 	)
 	return i, err
 }
 
-const getUserFromRefreshToken = `-- name: GetUserFromRefreshToken :one
-SELECT id, users.created_at, users.updated_at, email, hashed_password, is_red, token, refresh_tokens.created_at, refresh_tokens.updated_at, user_id, expires_at, revoked_at FROM users
-JOIN refresh_tokens ON users.id = refresh_tokens.user_id
-WHERE refresh_tokens.token = $1
-AND refresh_tokens.expires_at > NOW()
-AND refresh_tokens.revoked_at IS NULL
+const getUserByHandle = `-- name: GetUserByHandle :one
+SELECT id, created_at, updated_at, email, hashed_password, is_red, email_verified_at, pending_email, totp_secret, totp_enabled_at, totp_last_step, role, follower_count, following_count, fanout_on_read, handle, display_name, bio, avatar_url, website FROM users WHERE lower(handle) = lower($1)
 `
 
-type GetUserFromRefreshTokenRow struct {
-	ID             uuid.UUID
-	CreatedAt      sql.NullTime
-	UpdatedAt      sql.NullTime
-	Email          string
-	HashedPassword string
-	IsRed          bool
-	Token          string
-	CreatedAt_2    sql.NullTime
-	UpdatedAt_2    sql.NullTime
-	UserID         uuid.UUID
-	ExpiresAt      time.Time
-	RevokedAt      sql.NullTime
-}
-
-func (q *Queries) GetUserFromRefreshToken(ctx context.Context, token string) (GetUserFromRefreshTokenRow, error) {
-	row := q.db.QueryRowContext(ctx, getUserFromRefreshToken, token)
-	var i GetUserFromRefreshTokenRow
+func (q *Queries) GetUserByHandle(ctx context.Context, handle string) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByHandle, handle)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsRed,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.Role,
+		&i.FollowerCount,
+		&i.FollowingCount,
+		&i.FanoutOnRead,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.Website,
+	)
+	return i, err
+}
+
+const setPendingEmail = `-- name: SetPendingEmail :one
+UPDATE users
+SET pending_email = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, is_red, email_verified_at, pending_email, totp_secret, totp_enabled_at, totp_last_step, role, follower_count, following_count, fanout_on_read, handle, display_name, bio, avatar_url, website
+`
+
+type SetPendingEmailParams struct {
+	ID           uuid.UUID
+	PendingEmail sql.NullString
+}
+
+func (q *Queries) SetPendingEmail(ctx context.Context, arg SetPendingEmailParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, setPendingEmail, arg.ID, arg.PendingEmail)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsRed,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.Role,
+		&i.FollowerCount,
+		&i.FollowingCount,
+		&i.FanoutOnRead,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.Website,
+	)
+	return i, err
+}
+
+const setTOTPSecret = `-- name: SetTOTPSecret :one
+UPDATE users
+SET totp_secret = $2, updated_at = NOW()
+WHERE id = $1
+AND totp_enabled_at IS NULL
+RETURNING id, created_at, updated_at, email, hashed_password, is_red, email_verified_at, pending_email, totp_secret, totp_enabled_at, totp_last_step, role, follower_count, following_count, fanout_on_read, handle, display_name, bio, avatar_url, website
+`
+
+type SetTOTPSecretParams struct {
+	ID         uuid.UUID
+	TotpSecret sql.NullString
+}
+
+func (q *Queries) SetTOTPSecret(ctx context.Context, arg SetTOTPSecretParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, setTOTPSecret, arg.ID, arg.TotpSecret)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsRed,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.Role,
+		&i.FollowerCount,
+		&i.FollowingCount,
+		&i.FanoutOnRead,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.Website,
+	)
+	return i, err
+}
+
+const setUserRole = `-- name: SetUserRole :one
+UPDATE users
+SET role = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, is_red, email_verified_at, pending_email, totp_secret, totp_enabled_at, totp_last_step, role, follower_count, following_count, fanout_on_read, handle, display_name, bio, avatar_url, website
+`
+
+type SetUserRoleParams struct {
+	ID   uuid.UUID
+	Role string
+}
+
+func (q *Queries) SetUserRole(ctx context.Context, arg SetUserRoleParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, setUserRole, arg.ID, arg.Role)
+	var i User
 	err := row.Scan(
 		&i.ID,
 		&i.CreatedAt,
@@ -100,31 +313,58 @@ func (q *Queries) GetUserFromRefreshToken(ctx context.Context, token string) (Ge
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsRed,
-		&i.Token,
-		&i.CreatedAt_2,
-		&i.UpdatedAt_2,
-		&i.UserID,
-		&i.ExpiresAt,
-		&i.RevokedAt,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.Role,
+		&i.FollowerCount,
+		&i.FollowingCount,
+		&i.FanoutOnRead,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.Website,
 	)
 	return i, err
 }
 
-const updateUser = `-- name: UpdateUser :one
+const updateTOTPLastStep = `-- name: UpdateTOTPLastStep :execrows
+UPDATE users
+SET totp_last_step = $2
+WHERE id = $1
+AND totp_last_step < $2
+`
+
+type UpdateTOTPLastStepParams struct {
+	ID           uuid.UUID
+	TotpLastStep int64
+}
+
+func (q *Queries) UpdateTOTPLastStep(ctx context.Context, arg UpdateTOTPLastStepParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, updateTOTPLastStep, arg.ID, arg.TotpLastStep)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const updateUserPassword = `-- name: UpdateUserPassword :one
 UPDATE users
-SET email = $2, hashed_password = $3
+SET hashed_password = $2, updated_at = NOW()
 WHERE id = $1
-RETURNING id, created_at, updated_at, email, hashed_password, is_red
+RETURNING id, created_at, updated_at, email, hashed_password, is_red, email_verified_at, pending_email, totp_secret, totp_enabled_at, totp_last_step, role, follower_count, following_count, fanout_on_read, handle, display_name, bio, avatar_url, website
 `
 
-type UpdateUserParams struct {
+type UpdateUserPasswordParams struct {
 	ID             uuid.UUID
-	Email          string
 	HashedPassword string
 }
 
-func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, error) {
-	row := q.db.QueryRowContext(ctx, updateUser, arg.ID, arg.Email, arg.HashedPassword)
+func (q *Queries) UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, updateUserPassword, arg.ID, arg.HashedPassword)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -133,6 +373,71 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, e
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsRed,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.Role,
+		&i.FollowerCount,
+		&i.FollowingCount,
+		&i.FanoutOnRead,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.Website,
+	)
+	return i, err
+}
+
+const updateUserProfile = `-- name: UpdateUserProfile :one
+UPDATE users
+SET handle = $2, display_name = $3, bio = $4, avatar_url = $5, website = $6, updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, is_red, email_verified_at, pending_email, totp_secret, totp_enabled_at, totp_last_step, role, follower_count, following_count, fanout_on_read, handle, display_name, bio, avatar_url, website
+`
+
+type UpdateUserProfileParams struct {
+	ID          uuid.UUID
+	Handle      sql.NullString
+	DisplayName string
+	Bio         string
+	AvatarUrl   string
+	Website     string
+}
+
+func (q *Queries) UpdateUserProfile(ctx context.Context, arg UpdateUserProfileParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, updateUserProfile,
+		arg.ID,
+		arg.Handle,
+		arg.DisplayName,
+		arg.Bio,
+		arg.AvatarUrl,
+		arg.Website,
+	)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsRed,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.Role,
+		&i.FollowerCount,
+		&i.FollowingCount,
+		&i.FanoutOnRead,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.Website,
 	)
 	return i, err
 }
@@ -141,7 +446,7 @@ const upgradeUserToRed = `-- name: UpgradeUserToRed :one
 UPDATE users
 SET is_red = TRUE
 WHERE id = $1
-RETURNING id, created_at, updated_at, email, hashed_password, is_red
+RETURNING id, created_at, updated_at, email, hashed_password, is_red, email_verified_at, pending_email, totp_secret, totp_enabled_at, totp_last_step, role, follower_count, following_count, fanout_on_read, handle, display_name, bio, avatar_url, website
 `
 
 func (q *Queries) UpgradeUserToRed(ctx context.Context, id uuid.UUID) (User, error) {
@@ -154,6 +459,66 @@ func (q *Queries) UpgradeUserToRed(ctx context.Context, id uuid.UUID) (User, err
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsRed,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.Role,
+		&i.FollowerCount,
+		&i.FollowingCount,
+		&i.FanoutOnRead,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.Website,
+	)
+	return i, err
+}
+
+const verifyUserEmail = `-- name: VerifyUserEmail :one
+UPDATE users
+SET email = $1,
+    pending_email = CASE WHEN pending_email = $1 THEN NULL ELSE pending_email END,
+    email_verified_at = NOW(), updated_at = NOW()
+WHERE id = $2
+AND (email = $1 OR pending_email = $1)
+RETURNING id, created_at, updated_at, email, hashed_password, is_red, email_verified_at, pending_email, totp_secret, totp_enabled_at, totp_last_step, role, follower_count, following_count, fanout_on_read, handle, display_name, bio, avatar_url, website
+`
+
+type VerifyUserEmailParams struct {
+	Email string
+	ID    uuid.UUID
+}
+
+// The token must be for the current or the pending address, so an old link
+// cannot confirm an address the user has since replaced. Confirming the
+// current address leaves a pending change in place.
+func (q *Queries) VerifyUserEmail(ctx context.Context, arg VerifyUserEmailParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, verifyUserEmail, arg.Email, arg.ID)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsRed,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.Role,
+		&i.FollowerCount,
+		&i.FollowingCount,
+		&i.FanoutOnRead,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.Website,
 	)
 	return i, err
 }
@@ -0,0 +1,113 @@
+// Package events delivers chirp events to subscribers, such as the clients
+// of GET /api/stream, on every instance of the server.
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The types of events.
+const (
+	ChirpCreated = "chirp.created"
+	ChirpDeleted = "chirp.deleted"
+)
+
+// Event says that a chirp was created or deleted. IDs increase, so a
+// subscriber that knows the last event it saw can catch up on the rest
+// from wherever events are kept.
+type Event struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Type      string    `json:"type"`
+	ChirpID   uuid.UUID `json:"chirp_id"`
+	UserID    uuid.UUID `json:"user_id"`
+}
+
+// Bus passes events from publishers to every current subscriber.
+type Bus interface {
+	// Publish sends e to the subscribers. It does not wait for them to
+	// receive it.
+	Publish(ctx context.Context, e Event) error
+	// Subscribe starts receiving events. The caller must Close the
+	// subscription when done with it.
+	Subscribe() *Subscription
+}
+
+// subscriberBuffer is how many events a subscriber may fall behind before
+// it is dropped.
+const subscriberBuffer = 256
+
+// Subscription receives events on C. C is closed when the subscription is
+// closed, and also when the subscriber falls too far behind or the bus
+// may have missed events; the subscriber should then catch up from its
+// last event and subscribe again.
+type Subscription struct {
+	C <-chan Event
+
+	c   chan Event
+	hub *hub
+}
+
+// Close stops the subscription. Closing it again does nothing.
+func (s *Subscription) Close() {
+	s.hub.remove(s)
+}
+
+// hub fans events out to subscriptions. Sends never block: a subscriber
+// whose buffer is full is dropped rather than holding up everyone else.
+type hub struct {
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+func (h *hub) subscribe() *Subscription {
+	c := make(chan Event, subscriberBuffer)
+	s := &Subscription{C: c, c: c, hub: h}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subs == nil {
+		h.subs = map[*Subscription]struct{}{}
+	}
+	h.subs[s] = struct{}{}
+	return s
+}
+
+func (h *hub) remove(s *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subs[s]; ok {
+		delete(h.subs, s)
+		close(s.c)
+	}
+}
+
+func (h *hub) dispatch(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for s := range h.subs {
+		select {
+		case s.c <- e:
+		default:
+			delete(h.subs, s)
+			close(s.c)
+		}
+	}
+}
+
+// dropAll closes every subscription.
+func (h *hub) dropAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for s := range h.subs {
+		delete(h.subs, s)
+		close(s.c)
+	}
+}
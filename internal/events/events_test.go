@@ -0,0 +1,65 @@
+package events
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryBus(t *testing.T) {
+	ctx := context.Background()
+	bus := NewMemoryBus()
+
+	a := bus.Subscribe()
+	b := bus.Subscribe()
+	e := Event{ID: 1, Type: ChirpCreated, ChirpID: uuid.New(), UserID: uuid.New()}
+	assert.NoError(t, bus.Publish(ctx, e))
+	assert.Equal(t, e, <-a.C)
+	assert.Equal(t, e, <-b.C)
+
+	// A closed subscription gets nothing more, and closing it twice is
+	// fine.
+	a.Close()
+	a.Close()
+	_, ok := <-a.C
+	assert.False(t, ok)
+	assert.NoError(t, bus.Publish(ctx, Event{ID: 2, Type: ChirpDeleted}))
+	assert.Equal(t, int64(2), (<-b.C).ID)
+	b.Close()
+}
+
+func TestSlowSubscriberIsDropped(t *testing.T) {
+	ctx := context.Background()
+	bus := NewMemoryBus()
+
+	slow := bus.Subscribe()
+	defer slow.Close()
+	for i := 1; i <= subscriberBuffer+1; i++ {
+		assert.NoError(t, bus.Publish(ctx, Event{ID: int64(i), Type: ChirpCreated}))
+	}
+
+	// What was buffered is still delivered, then the channel is closed so
+	// the subscriber knows to catch up.
+	var last int64
+	for e := range slow.C {
+		last = e.ID
+	}
+	assert.Equal(t, int64(subscriberBuffer), last)
+
+	// Others are unaffected.
+	fast := bus.Subscribe()
+	defer fast.Close()
+	assert.NoError(t, bus.Publish(ctx, Event{ID: 1000, Type: ChirpCreated}))
+	assert.Equal(t, int64(1000), (<-fast.C).ID)
+}
+
+func TestDropAll(t *testing.T) {
+	var h hub
+	s := h.subscribe()
+	h.dropAll()
+	_, ok := <-s.C
+	assert.False(t, ok)
+	s.Close()
+}
@@ -0,0 +1,22 @@
+package events
+
+import "context"
+
+// MemoryBus delivers events within the process. It is meant for single
+// instance deployments and tests; replicas do not see each other's events.
+type MemoryBus struct {
+	hub hub
+}
+
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{}
+}
+
+func (b *MemoryBus) Publish(ctx context.Context, e Event) error {
+	b.hub.dispatch(e)
+	return nil
+}
+
+func (b *MemoryBus) Subscribe() *Subscription {
+	return b.hub.subscribe()
+}
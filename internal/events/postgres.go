@@ -0,0 +1,84 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/eefret/chirpy/internal/database"
+	"github.com/lib/pq"
+)
+
+// channel is the Postgres notification channel events are sent on.
+const channel = "chirp_events"
+
+// PostgresBus sends events through Postgres LISTEN/NOTIFY, so subscribers
+// on every replica receive the events published on any of them.
+type PostgresBus struct {
+	hub      hub
+	q        *database.Queries
+	listener *pq.Listener
+}
+
+// NewPostgresBus listens for events on a connection of its own to the
+// database at dsn, and publishes them through q.
+func NewPostgresBus(q *database.Queries, dsn string) (*PostgresBus, error) {
+	b := &PostgresBus{
+		q:        q,
+		listener: pq.NewListener(dsn, time.Second, time.Minute, nil),
+	}
+	if err := b.listener.Listen(channel); err != nil {
+		b.listener.Close()
+		return nil, err
+	}
+	go b.run()
+	return b, nil
+}
+
+func (b *PostgresBus) run() {
+	// Pinging now and then notices a dead connection even when no
+	// notifications are coming in.
+	ping := time.NewTicker(90 * time.Second)
+	defer ping.Stop()
+
+	for {
+		select {
+		case n, ok := <-b.listener.Notify:
+			if !ok {
+				b.hub.dropAll()
+				return
+			}
+			if n == nil {
+				// The connection was lost and has been re-established.
+				// Anything sent in between is gone, so subscribers have to
+				// catch up on their own.
+				b.hub.dropAll()
+				continue
+			}
+			var e Event
+			if err := json.Unmarshal([]byte(n.Extra), &e); err != nil {
+				continue
+			}
+			b.hub.dispatch(e)
+		case <-ping.C:
+			go b.listener.Ping()
+		}
+	}
+}
+
+func (b *PostgresBus) Publish(ctx context.Context, e Event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return b.q.NotifyChirpEvent(ctx, string(payload))
+}
+
+func (b *PostgresBus) Subscribe() *Subscription {
+	return b.hub.subscribe()
+}
+
+// Close stops listening and closes every subscription.
+func (b *PostgresBus) Close() error {
+	return b.listener.Close()
+}
@@ -0,0 +1,168 @@
+// Package imaging checks and prepares uploaded images: it recognises the
+// format from the content, applies and then drops EXIF metadata, and
+// scales images down to the sizes Chirpy serves.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+// Limits on the images Decode accepts, so a small file cannot expand into
+// an image that exhausts memory.
+const (
+	MaxSide   = 10000
+	MaxPixels = 40_000_000
+)
+
+// jpegQuality is used for every JPEG Chirpy writes.
+const jpegQuality = 85
+
+var (
+	ErrUnsupported = errors.New("imaging: unsupported image type")
+	ErrTooLarge    = errors.New("imaging: image is too large")
+)
+
+// Sniff returns the content type of data, judged by its content rather
+// than by what the client claims. Only JPEG, PNG and GIF are accepted.
+func Sniff(data []byte) (string, error) {
+	contentType := http.DetectContentType(data)
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif":
+		return contentType, nil
+	}
+	return "", ErrUnsupported
+}
+
+// Decode reads a JPEG, PNG or GIF image. JPEG images are turned upright
+// according to their EXIF orientation, since the metadata that records it
+// is not kept. Only the first frame of an animated GIF is read.
+func Decode(data []byte) (image.Image, string, error) {
+	contentType, err := Sniff(data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	if config.Width > MaxSide || config.Height > MaxSide || config.Width*config.Height > MaxPixels {
+		return nil, "", ErrTooLarge
+	}
+
+	var img image.Image
+	switch contentType {
+	case "image/jpeg":
+		img, err = jpeg.Decode(bytes.NewReader(data))
+		if err == nil {
+			img = orient(img, jpegOrientation(data))
+		}
+	case "image/png":
+		img, err = png.Decode(bytes.NewReader(data))
+	case "image/gif":
+		img, err = gif.Decode(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return img, contentType, nil
+}
+
+// Encode writes img as JPEG when it came from a JPEG and as PNG otherwise,
+// which keeps transparency. It returns the data and its content type. The
+// output never carries metadata from the upload.
+func Encode(img image.Image, sourceType string) ([]byte, string, error) {
+	var buf bytes.Buffer
+	if sourceType == "image/jpeg" {
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), "image/jpeg", nil
+	}
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), "image/png", nil
+}
+
+// Fit scales img down to fit within maxWidth by maxHeight, keeping its
+// aspect ratio. Images that already fit are returned as they are.
+func Fit(img image.Image, maxWidth, maxHeight int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= maxWidth && h <= maxHeight {
+		return img
+	}
+	if w*maxHeight > h*maxWidth {
+		h = max(1, h*maxWidth/w)
+		w = maxWidth
+	} else {
+		w = max(1, w*maxHeight/h)
+		h = maxHeight
+	}
+	return resize(img, w, h)
+}
+
+// Square crops the middle of img to a square and scales it to size by
+// size. Smaller images are scaled up, so every avatar has the same size.
+func Square(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	side := min(b.Dx(), b.Dy())
+	x := b.Min.X + (b.Dx()-side)/2
+	y := b.Min.Y + (b.Dy()-side)/2
+	crop := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(crop, crop.Bounds(), img, image.Pt(x, y), draw.Src)
+	return resize(crop, size, size)
+}
+
+// resize scales img to w by h. Each output pixel is the average of the
+// source pixels it covers, which scales down without the aliasing of
+// nearest-neighbour sampling.
+func resize(img image.Image, w, h int) *image.RGBA {
+	src := toRGBA(img)
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	for y := 0; y < h; y++ {
+		y0 := y * sh / h
+		y1 := max((y+1)*sh/h, y0+1)
+		for x := 0; x < w; x++ {
+			x0 := x * sw / w
+			x1 := max((x+1)*sw/w, x0+1)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r += uint64(p[0])
+					g += uint64(p[1])
+					b += uint64(p[2])
+					a += uint64(p[3])
+					n++
+				}
+			}
+			d := dst.Pix[y*dst.Stride+x*4:]
+			d[0], d[1], d[2], d[3] = uint8(r/n), uint8(g/n), uint8(b/n), uint8(a/n)
+		}
+	}
+	return dst
+}
+
+// toRGBA returns img as an *image.RGBA with its origin at 0, 0.
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Bounds().Min == (image.Point{}) {
+		return rgba
+	}
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Src)
+	return rgba
+}
@@ -0,0 +1,117 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// withOrientation inserts an EXIF segment recording orientation into a
+// JPEG file, the way cameras do.
+func withOrientation(t *testing.T, data []byte, orientation byte) []byte {
+	t.Helper()
+	tiff := []byte{
+		'M', 'M', 0, 42, 0, 0, 0, 8, // header, first IFD at 8
+		0, 1, // one entry
+		0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, orientation, 0, 0, // orientation, SHORT
+		0, 0, 0, 0, // no next IFD
+	}
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xFF, 0xE1, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)}
+	segment = append(segment, payload...)
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, segment...)
+	return append(out, data[2:]...)
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}))
+	return buf.Bytes()
+}
+
+func TestSniff(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 1, 1))))
+
+	contentType, err := Sniff(buf.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, "image/png", contentType)
+
+	// What the client calls the file does not matter, only its content.
+	_, err = Sniff([]byte("<html><script>alert(1)</script></html>"))
+	assert.ErrorIs(t, err, ErrUnsupported)
+}
+
+func TestDecodeAppliesOrientationAndEncodeDropsExif(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 40; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= 20 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			src.Set(x, y, c)
+		}
+	}
+	data := withOrientation(t, encodeJPEG(t, src), 6)
+	assert.Equal(t, 6, jpegOrientation(data))
+
+	img, contentType, err := Decode(data)
+	assert.NoError(t, err)
+	assert.Equal(t, "image/jpeg", contentType)
+	// Turned a quarter clockwise: the left half ends up on top.
+	assert.Equal(t, image.Rect(0, 0, 20, 40), img.Bounds())
+	r, _, b, _ := img.At(10, 5).RGBA()
+	assert.Greater(t, r, b)
+	r, _, b, _ = img.At(10, 35).RGBA()
+	assert.Greater(t, b, r)
+
+	out, outType, err := Encode(img, contentType)
+	assert.NoError(t, err)
+	assert.Equal(t, "image/jpeg", outType)
+	assert.False(t, bytes.Contains(out, []byte("Exif")))
+	assert.Equal(t, 1, jpegOrientation(out))
+}
+
+func TestDecodeRejectsHugeImages(t *testing.T) {
+	// A PNG header claiming a huge image is enough; Decode must not try to
+	// allocate it.
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, MaxSide+1, 1))))
+
+	_, _, err := Decode(buf.Bytes())
+	assert.ErrorIs(t, err, ErrTooLarge)
+}
+
+func TestFit(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 1000, 500))
+
+	assert.Equal(t, image.Rect(0, 0, 400, 200), Fit(img, 400, 400).Bounds())
+	assert.Equal(t, image.Rect(0, 0, 200, 100), Fit(img, 400, 100).Bounds())
+	// Small images are not scaled up.
+	assert.Equal(t, img.Bounds(), Fit(img, 2000, 2000).Bounds())
+}
+
+func TestSquare(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 300, 100))
+	for x := 100; x < 200; x++ {
+		for y := 0; y < 100; y++ {
+			img.Set(x, y, color.RGBA{G: 255, A: 255})
+		}
+	}
+
+	sq := Square(img, 50)
+	assert.Equal(t, image.Rect(0, 0, 50, 50), sq.Bounds())
+	// Only the green middle is kept.
+	_, g, _, a := sq.At(0, 0).RGBA()
+	assert.Equal(t, uint32(0xFFFF), g)
+	assert.Equal(t, uint32(0xFFFF), a)
+}
@@ -0,0 +1,129 @@
+// Package lockout slows down and locks out password guessing by counting
+// failed attempts per key, such as an account or a client IP.
+package lockout
+
+import (
+	"context"
+	"time"
+)
+
+// Attempts is the failure count recorded for a key.
+type Attempts struct {
+	Failures    int
+	LastFailure time.Time
+	// PreviousFailure is the failure before LastFailure, or zero if
+	// LastFailure started the count.
+	PreviousFailure time.Time
+}
+
+// Store keeps failure counts. Implementations must make RecordFailure
+// atomic, since replicas record failures for the same key concurrently.
+type Store interface {
+	// RecordFailure adds a failure for key and returns the new count. A
+	// failure more than window after the previous one starts a new count.
+	RecordFailure(ctx context.Context, key string, at time.Time, window time.Duration) (Attempts, error)
+	// RemoveFailure takes back one failure for key, if it has any.
+	RemoveFailure(ctx context.Context, key string) error
+	// Get returns the count for key, or zero Attempts if there is none.
+	Get(ctx context.Context, key string) (Attempts, error)
+	// Reset forgets every failure for key.
+	Reset(ctx context.Context, key string) error
+	// Prune drops keys whose last failure is before the given time.
+	Prune(ctx context.Context, before time.Time) error
+}
+
+// Policy decides how long a key has to wait after its failures.
+type Policy struct {
+	// FreeAttempts is how many failures are allowed before any delay.
+	FreeAttempts int
+	// BaseDelay is the wait after the first failure past FreeAttempts. It
+	// doubles with every further failure, up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// LockoutAfter failures lock the key for LockoutDuration.
+	LockoutAfter    int
+	LockoutDuration time.Duration
+	// Window is how long failures are remembered after the last one.
+	Window time.Duration
+}
+
+// Delay returns how long a key with the given number of failures has to
+// wait after its last failure.
+func (p Policy) Delay(failures int) time.Duration {
+	if p.LockoutAfter > 0 && failures >= p.LockoutAfter {
+		return p.LockoutDuration
+	}
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := p.FreeAttempts + 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, p.MaxDelay)
+}
+
+// Limiter applies a Policy to the keys of one kind, such as accounts.
+type Limiter struct {
+	store  Store
+	prefix string
+	policy Policy
+}
+
+// NewLimiter creates a limiter that stores its keys under prefix, so
+// limiters for different kinds of keys can share a store.
+func NewLimiter(store Store, prefix string, policy Policy) *Limiter {
+	return &Limiter{store: store, prefix: prefix, policy: policy}
+}
+
+// Check returns how long key has to wait before it may try again, or zero
+// if it may try now.
+func (l *Limiter) Check(ctx context.Context, key string, now time.Time) (time.Duration, error) {
+	attempts, err := l.store.Get(ctx, l.prefix+key)
+	if err != nil {
+		return 0, err
+	}
+	return l.wait(attempts, now), nil
+}
+
+// Fail records a failure for key and returns how long it now has to wait.
+func (l *Limiter) Fail(ctx context.Context, key string, now time.Time) (time.Duration, error) {
+	attempts, err := l.store.RecordFailure(ctx, l.prefix+key, now, l.policy.Window)
+	if err != nil {
+		return 0, err
+	}
+	return l.wait(attempts, now), nil
+}
+
+// Reserve counts an attempt for key as a failure before it is made, and
+// returns how long key had to wait before it, or zero if the attempt may go
+// ahead. Counting first means concurrent attempts cannot all pass before
+// any failure is recorded. Take back an attempt that succeeds with Release
+// or Reset.
+func (l *Limiter) Reserve(ctx context.Context, key string, now time.Time) (time.Duration, error) {
+	attempts, err := l.store.RecordFailure(ctx, l.prefix+key, now, l.policy.Window)
+	if err != nil {
+		return 0, err
+	}
+	before := Attempts{Failures: attempts.Failures - 1, LastFailure: attempts.PreviousFailure}
+	return l.wait(before, now), nil
+}
+
+// Release takes back an attempt counted by Reserve.
+func (l *Limiter) Release(ctx context.Context, key string) error {
+	return l.store.RemoveFailure(ctx, l.prefix+key)
+}
+
+// Reset forgets the failures for key, for example after a successful login
+// or when an admin unlocks the account.
+func (l *Limiter) Reset(ctx context.Context, key string) error {
+	return l.store.Reset(ctx, l.prefix+key)
+}
+
+func (l *Limiter) wait(attempts Attempts, now time.Time) time.Duration {
+	if attempts.Failures == 0 || now.Sub(attempts.LastFailure) > l.policy.Window {
+		return 0
+	}
+	return max(attempts.LastFailure.Add(l.policy.Delay(attempts.Failures)).Sub(now), 0)
+}
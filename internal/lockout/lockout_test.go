@@ -0,0 +1,157 @@
+package lockout
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testPolicy = Policy{
+	FreeAttempts:    2,
+	BaseDelay:       time.Second,
+	MaxDelay:        8 * time.Second,
+	LockoutAfter:    10,
+	LockoutDuration: 15 * time.Minute,
+	Window:          time.Hour,
+}
+
+// TestPolicyDelay ensures the delay doubles past the free attempts, is capped, and turns into a lockout.
+func TestPolicyDelay(t *testing.T) {
+	want := map[int]time.Duration{
+		0:  0,
+		2:  0,
+		3:  time.Second,
+		4:  2 * time.Second,
+		5:  4 * time.Second,
+		6:  8 * time.Second,
+		9:  8 * time.Second,
+		10: 15 * time.Minute,
+		50: 15 * time.Minute,
+	}
+	for failures, delay := range want {
+		assert.Equal(t, delay, testPolicy.Delay(failures), "failures=%d", failures)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	ctx := context.Background()
+	l := NewLimiter(NewMemoryStore(), "account:", testPolicy)
+	now := time.Now()
+
+	for range 2 {
+		wait, err := l.Fail(ctx, "a@example.com", now)
+		assert.NoError(t, err)
+		assert.Zero(t, wait)
+	}
+
+	wait, err := l.Fail(ctx, "a@example.com", now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Second, wait)
+
+	wait, err = l.Check(ctx, "a@example.com", now.Add(500*time.Millisecond))
+	assert.NoError(t, err)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	wait, err = l.Check(ctx, "a@example.com", now.Add(time.Second))
+	assert.NoError(t, err)
+	assert.Zero(t, wait)
+
+	wait, err = l.Check(ctx, "b@example.com", now)
+	assert.NoError(t, err)
+	assert.Zero(t, wait, "other keys are not affected")
+
+	assert.NoError(t, l.Reset(ctx, "a@example.com"))
+	wait, err = l.Fail(ctx, "a@example.com", now)
+	assert.NoError(t, err)
+	assert.Zero(t, wait, "reset starts the count over")
+}
+
+// TestLimiterReserve ensures attempts reserved at the same moment, as
+// concurrent logins are, still wait for the ones before them.
+func TestLimiterReserve(t *testing.T) {
+	ctx := context.Background()
+	l := NewLimiter(NewMemoryStore(), "account:", testPolicy)
+	now := time.Now()
+
+	for range 3 {
+		wait, err := l.Reserve(ctx, "a@example.com", now)
+		assert.NoError(t, err)
+		assert.Zero(t, wait)
+	}
+	wait, err := l.Reserve(ctx, "a@example.com", now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Second, wait)
+
+	for range 6 {
+		_, err := l.Reserve(ctx, "a@example.com", now)
+		assert.NoError(t, err)
+	}
+	wait, err = l.Reserve(ctx, "a@example.com", now)
+	assert.NoError(t, err)
+	assert.Equal(t, 15*time.Minute, wait, "a burst cannot get past the lockout")
+
+	// A released attempt no longer counts.
+	assert.NoError(t, l.Reset(ctx, "a@example.com"))
+	_, err = l.Reserve(ctx, "a@example.com", now)
+	assert.NoError(t, err)
+	assert.NoError(t, l.Release(ctx, "a@example.com"))
+	attempts, err := l.store.Get(ctx, "account:a@example.com")
+	assert.NoError(t, err)
+	assert.Zero(t, attempts.Failures)
+}
+
+// TestLimiterLockout ensures a locked key stays locked until the lockout expires.
+func TestLimiterLockout(t *testing.T) {
+	ctx := context.Background()
+	l := NewLimiter(NewMemoryStore(), "account:", testPolicy)
+	now := time.Now()
+
+	for range 10 {
+		_, err := l.Fail(ctx, "a@example.com", now)
+		assert.NoError(t, err)
+	}
+
+	wait, err := l.Check(ctx, "a@example.com", now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 14*time.Minute, wait)
+
+	wait, err = l.Check(ctx, "a@example.com", now.Add(15*time.Minute))
+	assert.NoError(t, err)
+	assert.Zero(t, wait)
+}
+
+// TestLimiterWindow ensures failures are forgotten once the window has passed.
+func TestLimiterWindow(t *testing.T) {
+	ctx := context.Background()
+	l := NewLimiter(NewMemoryStore(), "account:", testPolicy)
+	now := time.Now()
+
+	for range 5 {
+		_, err := l.Fail(ctx, "a@example.com", now)
+		assert.NoError(t, err)
+	}
+
+	wait, err := l.Fail(ctx, "a@example.com", now.Add(2*time.Hour))
+	assert.NoError(t, err)
+	assert.Zero(t, wait)
+}
+
+func TestMemoryStorePrune(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	now := time.Now()
+
+	_, err := s.RecordFailure(ctx, "old", now.Add(-2*time.Hour), time.Hour)
+	assert.NoError(t, err)
+	_, err = s.RecordFailure(ctx, "new", now, time.Hour)
+	assert.NoError(t, err)
+
+	assert.NoError(t, s.Prune(ctx, now.Add(-time.Hour)))
+
+	old, _ := s.Get(ctx, "old")
+	assert.Zero(t, old.Failures)
+	recent, _ := s.Get(ctx, "new")
+	assert.Equal(t, 1, recent.Failures)
+}
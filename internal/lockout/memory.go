@@ -0,0 +1,71 @@
+package lockout
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps failure counts in process. It is meant for single
+// instance deployments and tests; replicas each get their own counts.
+type MemoryStore struct {
+	mu       sync.Mutex
+	attempts map[string]Attempts
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{attempts: map[string]Attempts{}}
+}
+
+func (s *MemoryStore) RecordFailure(ctx context.Context, key string, at time.Time, window time.Duration) (Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempts := s.attempts[key]
+	if at.Sub(attempts.LastFailure) > window {
+		attempts = Attempts{}
+	}
+	attempts.Failures++
+	attempts.PreviousFailure = attempts.LastFailure
+	attempts.LastFailure = at
+	s.attempts[key] = attempts
+	return attempts, nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) (Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.attempts[key], nil
+}
+
+func (s *MemoryStore) RemoveFailure(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if attempts, ok := s.attempts[key]; ok && attempts.Failures > 0 {
+		attempts.Failures--
+		s.attempts[key] = attempts
+	}
+	return nil
+}
+
+func (s *MemoryStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+	return nil
+}
+
+func (s *MemoryStore) Prune(ctx context.Context, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, attempts := range s.attempts {
+		if attempts.LastFailure.Before(before) {
+			delete(s.attempts, key)
+		}
+	}
+	return nil
+}
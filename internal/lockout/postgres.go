@@ -0,0 +1,63 @@
+package lockout
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/eefret/chirpy/internal/database"
+)
+
+// PostgresStore keeps failure counts in the login_attempts table, so every
+// replica sees the same counts.
+type PostgresStore struct {
+	q *database.Queries
+}
+
+func NewPostgresStore(q *database.Queries) *PostgresStore {
+	return &PostgresStore{q: q}
+}
+
+func (s *PostgresStore) RecordFailure(ctx context.Context, key string, at time.Time, window time.Duration) (Attempts, error) {
+	row, err := s.q.RecordLoginFailure(ctx, database.RecordLoginFailureParams{
+		Key:         key,
+		FailedAt:    at,
+		WindowStart: at.Add(-window),
+	})
+	if err != nil {
+		return Attempts{}, err
+	}
+	return attemptsFromDB(row), nil
+}
+
+func (s *PostgresStore) RemoveFailure(ctx context.Context, key string) error {
+	return s.q.RemoveLoginFailure(ctx, key)
+}
+
+func (s *PostgresStore) Get(ctx context.Context, key string) (Attempts, error) {
+	row, err := s.q.GetLoginAttempts(ctx, key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Attempts{}, nil
+	}
+	if err != nil {
+		return Attempts{}, err
+	}
+	return attemptsFromDB(row), nil
+}
+
+func (s *PostgresStore) Reset(ctx context.Context, key string) error {
+	return s.q.ClearLoginAttempts(ctx, key)
+}
+
+func (s *PostgresStore) Prune(ctx context.Context, before time.Time) error {
+	return s.q.PruneLoginAttempts(ctx, before)
+}
+
+func attemptsFromDB(row database.LoginAttempt) Attempts {
+	return Attempts{
+		Failures:        int(row.Failures),
+		LastFailure:     row.LastFailureAt,
+		PreviousFailure: row.PreviousFailureAt.Time,
+	}
+}
@@ -0,0 +1,37 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogMailer(t *testing.T) {
+	var buf bytes.Buffer
+	m := NewLogMailer(&buf, "chirpy@example.com")
+
+	msg := Message{To: "user@example.com", Subject: "Hello", Body: "line one\nline two"}
+	assert.NoError(t, m.Send(context.Background(), msg))
+
+	assert.Equal(t, []Message{msg}, m.Messages())
+	assert.Contains(t, buf.String(), "To: user@example.com\r\n")
+	assert.Contains(t, buf.String(), "line one\r\nline two")
+}
+
+// TestBuildMessageHeaderInjection ensures line breaks in header values cannot add headers.
+func TestBuildMessageHeaderInjection(t *testing.T) {
+	msg := Message{
+		To:      "user@example.com\r\nBcc: victim@example.com",
+		Subject: "Hi\nBcc: victim@example.com",
+	}
+
+	raw := string(buildMessage("chirpy@example.com", msg, time.Now()))
+	headers := raw[:strings.Index(raw, "\r\n\r\n")]
+	for _, line := range strings.Split(headers, "\r\n") {
+		assert.False(t, strings.HasPrefix(line, "Bcc:"), "unexpected header %q", line)
+	}
+}
@@ -0,0 +1,258 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockProvider is a minimal OpenID Connect provider. It issues an ID token
+// for the code "good-code" when the PKCE verifier matches the challenge it
+// was given in challenge.
+type mockProvider struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu        sync.Mutex
+	key       *rsa.PrivateKey
+	kid       string
+	claims    jwt.MapClaims
+	challenge string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	m := &mockProvider{t: t}
+	m.rotateKey("key-1")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(Metadata{
+			Issuer:                m.server.URL,
+			AuthorizationEndpoint: m.server.URL + "/authorize",
+			TokenEndpoint:         m.server.URL + "/token",
+			JWKSURI:               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]any{"keys": []jsonWebKey{{
+			Kty: "RSA",
+			Kid: m.kid,
+			Use: "sig",
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != "chirpy" || secret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+		m.mu.Lock()
+		challenge := m.challenge
+		m.mu.Unlock()
+		if r.PostFormValue("code") != "good-code" || CodeChallenge(r.PostFormValue("code_verifier")) != challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(Tokens{IDToken: m.sign(m.claims), AccessToken: "access", TokenType: "Bearer"})
+	})
+
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+
+	now := time.Now()
+	m.claims = jwt.MapClaims{
+		"iss":            m.server.URL,
+		"sub":            "user-123",
+		"aud":            "chirpy",
+		"exp":            now.Add(time.Hour).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          "n-1",
+		"email":          "alice@example.com",
+		"email_verified": true,
+	}
+	return m
+}
+
+func (m *mockProvider) rotateKey(kid string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.key, m.kid = key, kid
+}
+
+func (m *mockProvider) sign(claims jwt.MapClaims) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = m.kid
+	signed, err := token.SignedString(m.key)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	return signed
+}
+
+func (m *mockProvider) with(changes jwt.MapClaims) jwt.MapClaims {
+	claims := jwt.MapClaims{}
+	for k, v := range m.claims {
+		claims[k] = v
+	}
+	for k, v := range changes {
+		claims[k] = v
+	}
+	return claims
+}
+
+func (m *mockProvider) provider() *Provider {
+	return NewProvider(Config{
+		Issuer:       m.server.URL,
+		ClientID:     "chirpy",
+		ClientSecret: "s3cret",
+		RedirectURL:  "http://localhost:8080/app/oidc-callback.html",
+	})
+}
+
+// TestLoginFlow runs discovery, the code exchange with PKCE and ID token validation against the mock provider.
+func TestLoginFlow(t *testing.T) {
+	m := newMockProvider(t)
+	p := m.provider()
+	ctx := context.Background()
+
+	verifier, err := RandomString()
+	assert.NoError(t, err)
+	m.challenge = CodeChallenge(verifier)
+
+	authURL, err := p.AuthCodeURL(ctx, "state-1", "n-1", m.challenge)
+	assert.NoError(t, err)
+	u, err := url.Parse(authURL)
+	assert.NoError(t, err)
+	assert.Equal(t, m.server.URL+"/authorize", u.Scheme+"://"+u.Host+u.Path)
+	assert.Equal(t, "chirpy", u.Query().Get("client_id"))
+	assert.Equal(t, "openid email profile", u.Query().Get("scope"))
+	assert.Equal(t, "S256", u.Query().Get("code_challenge_method"))
+	assert.Equal(t, "n-1", u.Query().Get("nonce"))
+
+	tokens, err := p.Exchange(ctx, "good-code", verifier)
+	assert.NoError(t, err)
+
+	idToken, err := p.VerifyIDToken(ctx, tokens.IDToken, "n-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "user-123", idToken.Subject)
+	assert.Equal(t, "alice@example.com", idToken.Email)
+	assert.True(t, bool(idToken.EmailVerified))
+}
+
+// TestExchangeWrongVerifier ensures the provider's error is reported.
+func TestExchangeWrongVerifier(t *testing.T) {
+	m := newMockProvider(t)
+	m.challenge = CodeChallenge("the-real-verifier")
+
+	_, err := m.provider().Exchange(context.Background(), "good-code", "another-verifier")
+	assert.ErrorContains(t, err, "invalid_grant")
+}
+
+// TestDiscoverIssuerMismatch ensures a provider cannot claim another issuer.
+func TestDiscoverIssuerMismatch(t *testing.T) {
+	m := newMockProvider(t)
+	p := NewProvider(Config{Issuer: m.server.URL + "/", ClientID: "chirpy"})
+
+	_, err := p.Discover(context.Background())
+	assert.ErrorContains(t, err, "does not match")
+}
+
+// TestVerifyIDTokenRejects ensures tokens with the wrong issuer, audience, lifetime or nonce are rejected.
+func TestVerifyIDTokenRejects(t *testing.T) {
+	m := newMockProvider(t)
+	p := m.provider()
+	ctx := context.Background()
+
+	tests := map[string]jwt.MapClaims{
+		"wrong issuer":   {"iss": "https://evil.example.com"},
+		"wrong audience": {"aud": "someone-else"},
+		"expired":        {"exp": time.Now().Add(-time.Hour).Unix()},
+		"no expiry":      {"exp": nil},
+		"wrong nonce":    {"nonce": "n-2"},
+		"other azp":      {"aud": []string{"chirpy", "other"}, "azp": "other"},
+	}
+	for name, changes := range tests {
+		claims := m.with(changes)
+		if exp, ok := changes["exp"]; ok && exp == nil {
+			delete(claims, "exp")
+		}
+		_, err := p.VerifyIDToken(ctx, m.sign(claims), "n-1")
+		assert.Error(t, err, name)
+	}
+
+	_, err := p.VerifyIDToken(ctx, m.sign(m.claims), "")
+	assert.Error(t, err, "empty nonce")
+}
+
+// TestVerifyIDTokenUnsigned ensures the none algorithm and HMAC are refused.
+func TestVerifyIDTokenUnsigned(t *testing.T) {
+	m := newMockProvider(t)
+	p := m.provider()
+
+	unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, m.claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	assert.NoError(t, err)
+	_, err = p.VerifyIDToken(context.Background(), unsigned, "n-1")
+	assert.Error(t, err)
+
+	hmac, err := jwt.NewWithClaims(jwt.SigningMethodHS256, m.claims).SignedString([]byte("s3cret"))
+	assert.NoError(t, err)
+	_, err = p.VerifyIDToken(context.Background(), hmac, "n-1")
+	assert.Error(t, err)
+}
+
+// TestVerifyIDTokenKeyRotation ensures the key set is refetched for an unknown kid.
+func TestVerifyIDTokenKeyRotation(t *testing.T) {
+	m := newMockProvider(t)
+	p := m.provider()
+	ctx := context.Background()
+
+	_, err := p.VerifyIDToken(ctx, m.sign(m.claims), "n-1")
+	assert.NoError(t, err)
+
+	m.rotateKey("key-2")
+	p.keysFetched = time.Time{}
+	_, err = p.VerifyIDToken(ctx, m.sign(m.claims), "n-1")
+	assert.NoError(t, err)
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// RFC 7636 appendix B.
+	assert.Equal(t, "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"))
+}
+
+func TestBoolish(t *testing.T) {
+	var claims struct {
+		A boolish `json:"a"`
+		B boolish `json:"b"`
+		C boolish `json:"c"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(`{"a": true, "b": "true", "c": "false"}`), &claims))
+	assert.True(t, bool(claims.A))
+	assert.True(t, bool(claims.B))
+	assert.False(t, bool(claims.C))
+}
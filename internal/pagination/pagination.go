@@ -0,0 +1,120 @@
+// Package pagination implements keyset pagination helpers: opaque cursors
+// that mark a position in an ordered listing, page size parsing and RFC 8288
+// Link headers.
+package pagination
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrInvalidLimit  = errors.New("invalid limit")
+)
+
+// Cursor is the position after the last item of a page, for listings
+// ordered by (created_at, id).
+type Cursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+// Encode returns the cursor as an opaque URL-safe string. Clients should
+// not rely on its format.
+func (c Cursor) Encode() string {
+	raw := strconv.FormatInt(c.CreatedAt.UnixMicro(), 10) + "." + c.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeCursor parses a cursor made by Encode.
+func DecodeCursor(s string) (Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	micros, id, ok := strings.Cut(string(raw), ".")
+	if !ok {
+		return Cursor{}, ErrInvalidCursor
+	}
+	t, err := strconv.ParseInt(micros, 10, 64)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	parsedID, err := uuid.Parse(id)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	// Postgres keeps microseconds, so the cursor loses nothing.
+	return Cursor{CreatedAt: time.UnixMicro(t).UTC(), ID: parsedID}, nil
+}
+
+// RankCursor is the position after the last item of a page, for listings
+// ordered by relevance and then id, such as search results.
+type RankCursor struct {
+	Rank float32
+	ID   uuid.UUID
+}
+
+// Encode returns the cursor as an opaque URL-safe string. The rank is kept
+// bit for bit, so the next page starts exactly where this one ended.
+func (c RankCursor) Encode() string {
+	raw := "r" + strconv.FormatUint(uint64(math.Float32bits(c.Rank)), 16) + "." + c.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeRankCursor parses a cursor made by RankCursor.Encode.
+func DecodeRankCursor(s string) (RankCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return RankCursor{}, ErrInvalidCursor
+	}
+
+	bits, id, ok := strings.Cut(string(raw), ".")
+	if !ok || !strings.HasPrefix(bits, "r") {
+		return RankCursor{}, ErrInvalidCursor
+	}
+	b, err := strconv.ParseUint(bits[1:], 16, 32)
+	if err != nil {
+		return RankCursor{}, ErrInvalidCursor
+	}
+	parsedID, err := uuid.Parse(id)
+	if err != nil {
+		return RankCursor{}, ErrInvalidCursor
+	}
+
+	return RankCursor{Rank: math.Float32frombits(uint32(b)), ID: parsedID}, nil
+}
+
+// ParseLimit parses a page size, returning def when s is empty. Sizes
+// outside 1..max are rejected rather than clamped, so clients notice.
+func ParseLimit(s string, def, max int) (int, error) {
+	if s == "" {
+		return def, nil
+	}
+	limit, err := strconv.Atoi(s)
+	if err != nil || limit < 1 || limit > max {
+		return 0, fmt.Errorf("%w: must be between 1 and %d", ErrInvalidLimit, max)
+	}
+	return limit, nil
+}
+
+// NextLink returns a Link header value (RFC 8288) pointing at the next
+// page: base with its query string, with cursor set to next.
+func NextLink(base *url.URL, next string) string {
+	u := *base
+	query := u.Query()
+	query.Set("cursor", next)
+	u.RawQuery = query.Encode()
+	return fmt.Sprintf("<%s>; rel=\"next\"", u.String())
+}
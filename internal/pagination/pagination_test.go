@@ -0,0 +1,82 @@
+package pagination
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCursorRoundTrip ensures a cursor decodes to the position it was made from.
+func TestCursorRoundTrip(t *testing.T) {
+	c := Cursor{
+		CreatedAt: time.Date(2024, 3, 1, 12, 30, 45, 123456000, time.UTC),
+		ID:        uuid.New(),
+	}
+
+	decoded, err := DecodeCursor(c.Encode())
+	assert.NoError(t, err)
+	assert.True(t, c.CreatedAt.Equal(decoded.CreatedAt))
+	assert.Equal(t, c.ID, decoded.ID)
+}
+
+// TestDecodeCursorInvalid ensures tampered or foreign cursors are rejected.
+func TestDecodeCursorInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"not base64!",
+		"bm8tZG90",            // "no-dot"
+		"YWJjLjEyMw",          // "abc.123"
+		"MTIzLm5vdC1hLXV1aWQ", // "123.not-a-uuid"
+	}
+	for _, s := range tests {
+		_, err := DecodeCursor(s)
+		assert.ErrorIs(t, err, ErrInvalidCursor, s)
+	}
+}
+
+// TestRankCursorRoundTrip ensures ranks survive encoding exactly.
+func TestRankCursorRoundTrip(t *testing.T) {
+	c := RankCursor{Rank: 0.1, ID: uuid.New()}
+
+	decoded, err := DecodeRankCursor(c.Encode())
+	assert.NoError(t, err)
+	assert.Equal(t, c, decoded)
+}
+
+// TestCursorKindsDoNotMix ensures a cursor from one kind of listing is rejected by another.
+func TestCursorKindsDoNotMix(t *testing.T) {
+	_, err := DecodeRankCursor(Cursor{CreatedAt: time.Now(), ID: uuid.New()}.Encode())
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+
+	_, err = DecodeCursor(RankCursor{Rank: 1, ID: uuid.New()}.Encode())
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestParseLimit(t *testing.T) {
+	limit, err := ParseLimit("", 20, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, 20, limit)
+
+	limit, err = ParseLimit("100", 20, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, 100, limit)
+
+	for _, s := range []string{"0", "-1", "101", "ten"} {
+		_, err := ParseLimit(s, 20, 100)
+		assert.ErrorIs(t, err, ErrInvalidLimit, s)
+	}
+}
+
+// TestNextLink ensures the link keeps the other query parameters and replaces the cursor.
+func TestNextLink(t *testing.T) {
+	base, err := url.Parse("https://chirpy.example.com/api/chirps?author_id=abc&sort=desc&limit=10&cursor=old")
+	assert.NoError(t, err)
+
+	assert.Equal(t,
+		`<https://chirpy.example.com/api/chirps?author_id=abc&cursor=new&limit=10&sort=desc>; rel="next"`,
+		NextLink(base, "new"))
+	assert.Equal(t, "old", base.Query().Get("cursor"))
+}
@@ -0,0 +1,147 @@
+// Package tsquery turns a search box query into Postgres to_tsquery syntax.
+// User input is never passed to to_tsquery directly, since its operators
+// would make ordinary punctuation a syntax error.
+//
+// The supported syntax is:
+//
+//	cat dog        both words
+//	"big red dog"  the words next to each other, in order
+//	chirp*         words starting with chirp
+//	-dog           without the word; works on phrases too
+//	cat OR dog     either word
+package tsquery
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// MaxTerms bounds how many terms a query may have.
+const MaxTerms = 16
+
+var (
+	ErrEmpty        = errors.New("query has no search terms")
+	ErrOnlyExcluded = errors.New("query must include at least one term")
+	ErrTooManyTerms = errors.New("query has too many terms")
+)
+
+type term struct {
+	lexemes []string
+	prefix  bool
+	negated bool
+}
+
+func (t term) String() string {
+	parts := make([]string, len(t.lexemes))
+	for i, lexeme := range t.lexemes {
+		// Lexemes only hold letters and digits, so quoting cannot be escaped.
+		parts[i] = "'" + lexeme + "'"
+	}
+	if t.prefix {
+		parts[len(parts)-1] += ":*"
+	}
+
+	s := strings.Join(parts, " <-> ")
+	if len(parts) > 1 {
+		s = "(" + s + ")"
+	}
+	if t.negated {
+		s = "!" + s
+	}
+	return s
+}
+
+// Parse converts a query to to_tsquery syntax. Terms are ANDed together
+// unless joined by OR. Punctuation separates words, so a term like don't
+// becomes the phrase "don t", which is how to_tsvector splits it too.
+func Parse(input string) (string, error) {
+	// Each group is a list of alternatives joined by OR.
+	var groups [][]term
+	count := 0
+	or := false
+
+	rest := input
+	for {
+		rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+		if rest == "" {
+			break
+		}
+
+		negated := false
+		if rest[0] == '-' {
+			negated = true
+			rest = rest[1:]
+		}
+
+		var raw string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				// An unterminated phrase runs to the end of the query.
+				raw, rest = rest[1:], ""
+			} else {
+				raw, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			end := strings.IndexFunc(rest, unicode.IsSpace)
+			if end < 0 {
+				end = len(rest)
+			}
+			raw, rest = rest[:end], rest[end:]
+			if raw == "OR" && !negated {
+				or = len(groups) > 0
+				continue
+			}
+		}
+
+		lexemes := strings.FieldsFunc(strings.ToLower(raw), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+		})
+		if len(lexemes) == 0 {
+			or = false
+			continue
+		}
+
+		count++
+		if count > MaxTerms {
+			return "", ErrTooManyTerms
+		}
+
+		t := term{lexemes: lexemes, prefix: strings.HasSuffix(raw, "*"), negated: negated}
+		if or {
+			groups[len(groups)-1] = append(groups[len(groups)-1], t)
+		} else {
+			groups = append(groups, []term{t})
+		}
+		or = false
+	}
+
+	if len(groups) == 0 {
+		return "", ErrEmpty
+	}
+
+	// A query that only excludes words matches nearly every chirp and
+	// cannot use the index.
+	positive := false
+	parts := make([]string, len(groups))
+	for i, group := range groups {
+		alternatives := make([]string, len(group))
+		groupPositive := true
+		for j, t := range group {
+			alternatives[j] = t.String()
+			groupPositive = groupPositive && !t.negated
+		}
+		positive = positive || groupPositive
+
+		parts[i] = strings.Join(alternatives, " | ")
+		if len(group) > 1 {
+			parts[i] = "(" + parts[i] + ")"
+		}
+	}
+	if !positive {
+		return "", ErrOnlyExcluded
+	}
+
+	return strings.Join(parts, " & "), nil
+}
@@ -0,0 +1,54 @@
+package tsquery
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	tests := map[string]string{
+		"cat":                  "'cat'",
+		"Cat  dog":             "'cat' & 'dog'",
+		`"big red dog"`:        "('big' <-> 'red' <-> 'dog')",
+		"chirp*":               "'chirp':*",
+		`"big red*"`:           "('big' <-> 'red':*)",
+		"cat -dog":             "'cat' & !'dog'",
+		`cat -"big dog"`:       "'cat' & !('big' <-> 'dog')",
+		"cat OR dog bird":      "('cat' | 'dog') & 'bird'",
+		"OR cat":               "'cat'",
+		"don't":                "('don' <-> 't')",
+		"café naïve":           "'café' & 'naïve'",
+		`"unterminated phrase`: "('unterminated' <-> 'phrase')",
+		"cat - dog":            "'cat' & 'dog'",
+	}
+	for input, want := range tests {
+		got, err := Parse(input)
+		assert.NoError(t, err, input)
+		assert.Equal(t, want, got, input)
+	}
+}
+
+// TestParseEscapesOperators ensures tsquery syntax in the input is treated as text.
+func TestParseEscapesOperators(t *testing.T) {
+	got, err := Parse(`'); DROP TABLE chirps; -- & | ! <-> :*`)
+	assert.NoError(t, err)
+	assert.Equal(t, "'drop' & 'table' & 'chirps'", got)
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := map[string]error{
+		"":                                  ErrEmpty,
+		"   ":                               ErrEmpty,
+		"!!! ???":                           ErrEmpty,
+		"-cat":                              ErrOnlyExcluded,
+		"-cat -dog":                         ErrOnlyExcluded,
+		"cat OR -dog":                       ErrOnlyExcluded,
+		strings.Repeat("word ", MaxTerms+1): ErrTooManyTerms,
+	}
+	for input, want := range tests {
+		_, err := Parse(input)
+		assert.ErrorIs(t, err, want, input)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/eefret/chirpy/internal/auth"
+	"github.com/eefret/chirpy/internal/database"
+	"github.com/eefret/chirpy/internal/pagination"
+	"github.com/google/uuid"
+)
+
+type ChirpLike struct {
+	UserID  uuid.UUID `json:"user_id"`
+	LikedAt time.Time `json:"liked_at"`
+}
+
+// setLikedByMe sets LikedByMe on the chirps viewer has liked. It does
+// nothing for anonymous requests.
+func (cfg *apiConfig) setLikedByMe(r *http.Request, viewer uuid.UUID, chirps ...*Chirp) {
+	if viewer == uuid.Nil || len(chirps) == 0 {
+		return
+	}
+
+	ids := make([]uuid.UUID, 0, len(chirps))
+	for _, c := range chirps {
+		ids = append(ids, c.ID)
+	}
+	liked, err := cfg.DB.ListLikedChirpIDs(r.Context(), database.ListLikedChirpIDsParams{
+		UserID:   viewer,
+		ChirpIds: ids,
+	})
+	if err != nil {
+		// The chirps are still worth returning without it.
+		fmt.Println("Error listing liked chirps:", err)
+		return
+	}
+
+	likedSet := make(map[uuid.UUID]bool, len(liked))
+	for _, id := range liked {
+		likedSet[id] = true
+	}
+	for _, c := range chirps {
+		c.LikedByMe = likedSet[c.ID]
+	}
+}
+
+// chirpPtrs returns pointers to the chirps of a response, for fillChirps.
+func chirpPtrs(chirps []Chirp) []*Chirp {
+	ptrs := make([]*Chirp, 0, len(chirps))
+	for i := range chirps {
+		ptrs = append(ptrs, &chirps[i])
+	}
+	return ptrs
+}
+
+func (cfg *apiConfig) handleLikeChirp(w http.ResponseWriter, r *http.Request) {
+	caller, ok := cfg.authenticate(w, r, auth.ScopeChirpsWrite)
+	if !ok {
+		return
+	}
+
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	chirp, err := cfg.originalChirp(r.Context(), chirpID)
+	if err != nil || cfg.blocked(r.Context(), caller.UserID, chirp.UserID) {
+		respondWithError(w, http.StatusNotFound, "Chirp not found")
+		return
+	}
+
+	// Liking a chirp twice is not an error; it stays liked once.
+	_, err = cfg.DB.LikeChirp(r.Context(), database.LikeChirpParams{
+		UserID:  caller.UserID,
+		ChirpID: chirp.ID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not like chirp")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handleUnlikeChirp(w http.ResponseWriter, r *http.Request) {
+	caller, ok := cfg.authenticate(w, r, auth.ScopeChirpsWrite)
+	if !ok {
+		return
+	}
+
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	// The like of a rechirp went to the chirp it reposts.
+	if chirp, err := cfg.DB.GetChirp(r.Context(), chirpID); err == nil && chirp.RechirpOf.Valid {
+		chirpID = chirp.RechirpOf.UUID
+	}
+
+	// Unliking a chirp that is not liked is not an error either.
+	_, err = cfg.DB.UnlikeChirp(r.Context(), database.UnlikeChirpParams{
+		UserID:  caller.UserID,
+		ChirpID: chirpID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not unlike chirp")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleChirpLikes lists who liked a chirp, most recent first.
+func (cfg *apiConfig) handleChirpLikes(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	page, ok := parsePageRequest(w, r)
+	if !ok {
+		return
+	}
+
+	viewer := cfg.viewer(r)
+	chirp, err := cfg.originalChirp(r.Context(), chirpID)
+	if err != nil || cfg.blocked(r.Context(), viewer, chirp.UserID) {
+		respondWithError(w, http.StatusNotFound, "Chirp not found")
+		return
+	}
+
+	likes, err := cfg.DB.ListChirpLikes(r.Context(), database.ListChirpLikesParams{
+		ChirpID:         chirp.ID,
+		BeforeCreatedAt: sql.NullTime{Time: page.Cursor.CreatedAt, Valid: page.HasCursor},
+		BeforeUserID:    uuid.NullUUID{UUID: page.Cursor.ID, Valid: page.HasCursor},
+		ViewerID:        nullViewer(viewer),
+		MaxRows:         page.maxRows(),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not list likes")
+		return
+	}
+
+	type LikesPage struct {
+		Likes      []ChirpLike `json:"likes"`
+		NextCursor *string     `json:"next_cursor"`
+	}
+
+	response := LikesPage{Likes: []ChirpLike{}}
+	likes, response.NextCursor = nextPage(cfg, w, r, page, likes, func(last database.ChirpLike) string {
+		return pagination.Cursor{CreatedAt: last.CreatedAt, ID: last.UserID}.Encode()
+	})
+	for _, like := range likes {
+		response.Likes = append(response.Likes, ChirpLike{UserID: like.UserID, LikedAt: like.CreatedAt})
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// handleUserLikes lists the chirps a user has liked, most recently liked
+// first.
+func (cfg *apiConfig) handleUserLikes(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	page, ok := parsePageRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := cfg.DB.GetUser(r.Context(), userID); err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	rows, err := cfg.DB.ListLikedChirps(r.Context(), database.ListLikedChirpsParams{
+		UserID:        userID,
+		BeforeLikedAt: sql.NullTime{Time: page.Cursor.CreatedAt, Valid: page.HasCursor},
+		BeforeID:      uuid.NullUUID{UUID: page.Cursor.ID, Valid: page.HasCursor},
+		ViewerID:      nullViewer(cfg.viewer(r)),
+		MaxRows:       page.maxRows(),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not list likes")
+		return
+	}
+
+	type ChirpPage struct {
+		Chirps     []Chirp `json:"chirps"`
+		NextCursor *string `json:"next_cursor"`
+	}
+
+	response := ChirpPage{Chirps: []Chirp{}}
+	rows, response.NextCursor = nextPage(cfg, w, r, page, rows, func(last database.ListLikedChirpsRow) string {
+		// The cursor is the time of the like, not of the chirp.
+		return pagination.Cursor{CreatedAt: last.LikedAt, ID: last.Chirp.ID}.Encode()
+	})
+	for _, row := range rows {
+		response.Chirps = append(response.Chirps, chirpFromDB(row.Chirp))
+	}
+	cfg.fillChirps(r, chirpPtrs(response.Chirps)...)
+
+	respondWithJSON(w, http.StatusOK, response)
+}
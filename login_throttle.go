@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eefret/chirpy/internal/lockout"
+	"github.com/google/uuid"
+)
+
+// Failed logins are counted per account and per client IP. The account
+// limit stops guessing against one user from many addresses; the looser IP
+// limit stops one address from trying a few passwords on many accounts.
+var (
+	accountLoginPolicy = lockout.Policy{
+		FreeAttempts:    3,
+		BaseDelay:       time.Second,
+		MaxDelay:        time.Minute,
+		LockoutAfter:    10,
+		LockoutDuration: 15 * time.Minute,
+		Window:          24 * time.Hour,
+	}
+	ipLoginPolicy = lockout.Policy{
+		FreeAttempts:    20,
+		BaseDelay:       time.Second,
+		MaxDelay:        time.Minute,
+		LockoutAfter:    100,
+		LockoutDuration: 15 * time.Minute,
+		Window:          time.Hour,
+	}
+)
+
+// pruneLoginAttempts periodically drops failure counts nobody remembers anymore.
+func pruneLoginAttempts(store lockout.Store, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	window := max(accountLoginPolicy.Window, ipLoginPolicy.Window)
+	for now := range ticker.C {
+		if err := store.Prune(context.Background(), now.Add(-window)); err != nil {
+			fmt.Println("Error pruning login attempts:", err)
+		}
+	}
+}
+
+// accountKey is the key failures for an email are counted under. Unknown
+// emails are counted and take as long to check as known ones, so neither
+// lockouts nor response times reveal which accounts exist.
+func accountKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// reserveLoginAttempt responds with 429 and returns false while the
+// account or the client has to wait before trying again. Otherwise the
+// attempt is counted as a failure before the password or code is checked,
+// so a burst of concurrent guesses cannot all get in before the first
+// failure is recorded. An attempt that succeeds is taken back with
+// releaseLoginAttempt or clearLoginFailures.
+func (cfg *apiConfig) reserveLoginAttempt(w http.ResponseWriter, r *http.Request, email string) bool {
+	now := time.Now()
+
+	// Checking first keeps clients that retry while they wait from
+	// extending their own wait.
+	accountWait, err := cfg.accountLimiter.Check(r.Context(), accountKey(email), now)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return false
+	}
+	ipWait, err := cfg.ipLimiter.Check(r.Context(), cfg.clientIP(r), now)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return false
+	}
+
+	if max(accountWait, ipWait) == 0 {
+		accountWait, err = cfg.accountLimiter.Reserve(r.Context(), accountKey(email), now)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+			return false
+		}
+		ipWait, err = cfg.ipLimiter.Reserve(r.Context(), cfg.clientIP(r), now)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+			return false
+		}
+	}
+
+	if wait := max(accountWait, ipWait); wait > 0 {
+		cfg.audit(r, auditEvent{
+			Action:   auditLoginThrottled,
+			Metadata: map[string]any{"email": email, "retry_after": int(math.Ceil(wait.Seconds()))},
+		})
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		respondWithError(w, http.StatusTooManyRequests, "Too many failed attempts, try again later")
+		return false
+	}
+	return true
+}
+
+// recordLoginFailure records a wrong password or second factor in the
+// audit log. reserveLoginAttempt has already counted it. userID is
+// uuid.Nil when no account has the email.
+func (cfg *apiConfig) recordLoginFailure(r *http.Request, email string, userID uuid.UUID, reason string) {
+	event := auditEvent{
+		Action:   auditLoginFailed,
+		Metadata: map[string]any{"email": email, "reason": reason},
+	}
+	if userID != uuid.Nil {
+		event.TargetType = "user"
+		event.TargetID = userID.String()
+	}
+	cfg.audit(r, event)
+}
+
+// releaseLoginAttempt takes back an attempt that passed a login step
+// without finishing the login, such as a correct password before the
+// second factor.
+func (cfg *apiConfig) releaseLoginAttempt(r *http.Request, email string) {
+	if err := cfg.accountLimiter.Release(r.Context(), accountKey(email)); err != nil {
+		fmt.Println("Error releasing login attempt:", err)
+	}
+	if err := cfg.ipLimiter.Release(r.Context(), cfg.clientIP(r)); err != nil {
+		fmt.Println("Error releasing login attempt:", err)
+	}
+}
+
+// clearLoginFailures resets the account's count after a successful login.
+// The IP count only loses the successful attempt, or an attacker could
+// reset it by logging in to an account of their own between guesses.
+func (cfg *apiConfig) clearLoginFailures(r *http.Request, email string) {
+	if err := cfg.accountLimiter.Reset(r.Context(), accountKey(email)); err != nil {
+		fmt.Println("Error clearing failed logins:", err)
+	}
+	if err := cfg.ipLimiter.Release(r.Context(), cfg.clientIP(r)); err != nil {
+		fmt.Println("Error releasing login attempt:", err)
+	}
+}
+
+func (cfg *apiConfig) handleUnlockUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	u, err := cfg.DB.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	if err := cfg.accountLimiter.Reset(r.Context(), accountKey(u.Email)); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not unlock user")
+		return
+	}
+
+	cfg.audit(r, auditEvent{
+		Actor:      adminActor(r),
+		Action:     auditUserUnlocked,
+		TargetType: "user",
+		TargetID:   u.ID.String(),
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
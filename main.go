@@ -1,24 +1,148 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"sync/atomic"
+	"time"
 
+	"github.com/eefret/chirpy/internal/auth"
+	"github.com/eefret/chirpy/internal/blobstore"
 	"github.com/eefret/chirpy/internal/database"
+	"github.com/eefret/chirpy/internal/events"
+	"github.com/eefret/chirpy/internal/lockout"
+	"github.com/eefret/chirpy/internal/mailer"
+	"github.com/eefret/chirpy/internal/oidc"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 )
 
 type apiConfig struct {
 	fileserverHits atomic.Int32
+	db             *sql.DB
 	DB             *database.Queries
-	authSecret     string
+	jwtKeys        *auth.KeyRing
+	tokenPepper    string
 	polkaKey       string
+	trustProxy     bool
+	platform       string
+	publicURL      string
+	mailer         mailer.Mailer
+	accountLimiter *lockout.Limiter
+	ipLimiter      *lockout.Limiter
+	oidc           *oidc.Provider
+	// editWindow limits how long chirps can be edited after posting, with
+	// editWindowRed for Chirpy Red users. Zero means no limit.
+	editWindow    time.Duration
+	editWindowRed time.Duration
+	// fanoutLimit is the number of followers above which an account's
+	// chirps are read into timelines instead of copied into each one.
+	fanoutLimit int32
+	// requireVerifiedEmail blocks users from chirping until they confirm their email.
+	requireVerifiedEmail bool
+	// blobs holds uploaded images, which are linked to under mediaBaseURL.
+	blobs        blobstore.BlobStore
+	mediaBaseURL string
+	// events carries chirp events to the clients of GET /api/stream.
+	events events.Bus
 }
 
+const (
+	accessTokenTTL  = time.Hour
+	refreshTokenTTL = 60 * 24 * time.Hour
+)
+
+// rotateSigningKeys periodically replaces the active JWT signing key and
+// drops retired keys once every token they signed has expired.
+func rotateSigningKeys(keys *auth.KeyRing, every time.Duration) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		if err := keys.Reload(); err != nil {
+			fmt.Println("Error reloading signing keys:", err)
+			continue
+		}
+		if _, err := keys.RotateIfDue(now, every); err != nil {
+			fmt.Println("Error rotating signing keys:", err)
+		}
+		if err := keys.Prune(now); err != nil {
+			fmt.Println("Error pruning signing keys:", err)
+		}
+	}
+}
+
+// hashLegacyRefreshTokens replaces refresh tokens that were stored before
+// hashing was introduced with their hash, so existing sessions keep working.
+func hashLegacyRefreshTokens(ctx context.Context, q *database.Queries, pepper string) error {
+	tokens, err := q.ListLegacyRefreshTokens(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, token := range tokens {
+		err := q.HashLegacyRefreshToken(ctx, database.HashLegacyRefreshTokenParams{
+			NewHash:  auth.HashToken(token, pepper),
+			OldToken: token,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// newMailer picks the mail delivery configured in the environment. Without
+// MAILER=smtp, mail is written to MAIL_LOG_FILE or stdout.
+func newMailer() (mailer.Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "chirpy@localhost"
+	}
+
+	if os.Getenv("MAILER") == "smtp" {
+		return mailer.NewSMTPMailer(os.Getenv("SMTP_ADDR"), os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), from), nil
+	}
 
+	if path := os.Getenv("MAIL_LOG_FILE"); path != "" {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		return mailer.NewLogMailer(f, from), nil
+	}
+	return mailer.NewLogMailer(os.Stdout, from), nil
+}
+
+// newBlobStore picks where uploads are kept. Without MEDIA_STORE=s3, they
+// are written under MEDIA_DIR.
+func newBlobStore() blobstore.BlobStore {
+	if os.Getenv("MEDIA_STORE") == "s3" {
+		region := os.Getenv("S3_REGION")
+		if region == "" {
+			region = "us-east-1"
+		}
+		return blobstore.NewS3Store(blobstore.S3Config{
+			Endpoint:        os.Getenv("S3_ENDPOINT"),
+			Region:          region,
+			Bucket:          os.Getenv("S3_BUCKET"),
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+		}, nil)
+	}
+
+	dir := os.Getenv("MEDIA_DIR")
+	if dir == "" {
+		dir = "media"
+	}
+	return blobstore.NewFileStore(dir)
+}
 
 func main() {
 	mux := http.ServeMux{}
@@ -40,30 +164,210 @@ func main() {
 		panic(err)
 	}
 
-	cfg.authSecret = os.Getenv("AUTH_SECRET")
 	cfg.polkaKey = os.Getenv("POLKA_KEY")
 
+	cfg.trustProxy = os.Getenv("TRUST_PROXY") == "true"
+	// Destructive admin operations are refused unless PLATFORM=dev.
+	cfg.platform = os.Getenv("PLATFORM")
+
+	cfg.requireVerifiedEmail = os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true"
+
+	cfg.publicURL = os.Getenv("PUBLIC_URL")
+	if cfg.publicURL == "" {
+		cfg.publicURL = "http://localhost:8080"
+	}
+
+	cfg.blobs = newBlobStore()
+	// Uploads are served by GET /media/ unless MEDIA_PUBLIC_URL points
+	// somewhere else, such as the bucket or a CDN in front of it.
+	cfg.mediaBaseURL = os.Getenv("MEDIA_PUBLIC_URL")
+	if cfg.mediaBaseURL == "" {
+		cfg.mediaBaseURL = cfg.publicURL + "/media/"
+	}
+	if !strings.HasSuffix(cfg.mediaBaseURL, "/") {
+		cfg.mediaBaseURL += "/"
+	}
+
+	cfg.mailer, err = newMailer()
+	if err != nil {
+		panic(err)
+	}
+
+	if v := os.Getenv("PASSWORD_HASH_PARAMS"); v != "" {
+		params, err := auth.ParseArgon2Params(v)
+		if err != nil {
+			panic(err)
+		}
+		if err := auth.SetArgon2Params(params); err != nil {
+			panic(err)
+		}
+	}
+
+	if v := os.Getenv("CHIRP_EDIT_WINDOW"); v != "" {
+		cfg.editWindow, err = time.ParseDuration(v)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if v := os.Getenv("CHIRP_EDIT_WINDOW_RED"); v != "" {
+		cfg.editWindowRed, err = time.ParseDuration(v)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	cfg.fanoutLimit = defaultFanoutLimit
+	if v := os.Getenv("TIMELINE_FANOUT_LIMIT"); v != "" {
+		limit, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			panic(err)
+		}
+		cfg.fanoutLimit = int32(limit)
+	}
+
+	// Single sign-on is only offered when an identity provider is configured.
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		cfg.oidc = oidc.NewProvider(oidc.Config{
+			Issuer:       issuer,
+			ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  cfg.publicURL + "/app/oidc-callback.html",
+		})
+	}
+
+	cfg.tokenPepper = os.Getenv("TOKEN_PEPPER")
+	if cfg.tokenPepper == "" {
+		panic("TOKEN_PEPPER must be set")
+	}
+
+	cfg.jwtKeys, err = auth.NewKeyRing(os.Getenv("JWT_ALG"), os.Getenv("JWT_KEYS_DIR"), accessTokenTTL)
+	if err != nil {
+		panic(err)
+	}
+
+	rotateEvery := 30 * 24 * time.Hour
+	if v := os.Getenv("JWT_ROTATE_EVERY"); v != "" {
+		rotateEvery, err = time.ParseDuration(v)
+		if err != nil {
+			panic(err)
+		}
+	}
+	go rotateSigningKeys(cfg.jwtKeys, rotateEvery)
+
+	cfg.db = db
 	cfg.DB = database.New(db)
 
+	if err := hashLegacyRefreshTokens(context.Background(), cfg.DB, cfg.tokenPepper); err != nil {
+		panic(err)
+	}
+
+	// Failed logins are counted in Postgres so every replica enforces the
+	// same limits. LOGIN_ATTEMPT_STORE=memory suits a single instance.
+	var attempts lockout.Store = lockout.NewPostgresStore(cfg.DB)
+	if os.Getenv("LOGIN_ATTEMPT_STORE") == "memory" {
+		attempts = lockout.NewMemoryStore()
+	}
+	cfg.accountLimiter = lockout.NewLimiter(attempts, "account:", accountLoginPolicy)
+	cfg.ipLimiter = lockout.NewLimiter(attempts, "ip:", ipLoginPolicy)
+	go pruneLoginAttempts(attempts, time.Hour)
+
+	// Chirp events reach streams on every replica through Postgres.
+	// EVENT_BUS=memory suits a single instance.
+	if os.Getenv("EVENT_BUS") == "memory" {
+		cfg.events = events.NewMemoryBus()
+	} else {
+		cfg.events, err = events.NewPostgresBus(cfg.DB, dbURL)
+		if err != nil {
+			panic(err)
+		}
+	}
+	go pruneChirpEvents(cfg.DB, time.Hour)
+	go cfg.pruneOrphanedMedia(time.Hour)
+
 	mux.Handle("/app/", cfg.middlewareMetricsInc(http.StripPrefix("/app/", http.FileServer(http.Dir("app")))))
 
-	mux.HandleFunc("GET /admin/metrics", cfg.handleMetrics)
-	mux.HandleFunc("POST /admin/reset", cfg.handleReset)
+	mux.Handle("GET /admin/metrics", cfg.middlewareRequireRole(auth.RoleAdmin, http.HandlerFunc(cfg.handleMetrics)))
+	mux.Handle("POST /admin/reset", cfg.middlewareRequireRole(auth.RoleAdmin, http.HandlerFunc(cfg.handleReset)))
+	mux.Handle("PUT /admin/users/{userID}/role", cfg.middlewareRequireRole(auth.RoleAdmin, http.HandlerFunc(cfg.handleSetUserRole)))
+	mux.Handle("POST /admin/users/{userID}/unlock", cfg.middlewareRequireRole(auth.RoleAdmin, http.HandlerFunc(cfg.handleUnlockUser)))
+	mux.Handle("POST /admin/oauth/clients", cfg.middlewareRequireRole(auth.RoleAdmin, http.HandlerFunc(cfg.handleCreateOAuthClient)))
+	mux.Handle("GET /admin/oauth/clients", cfg.middlewareRequireRole(auth.RoleAdmin, http.HandlerFunc(cfg.handleListOAuthClients)))
+	mux.Handle("DELETE /admin/oauth/clients/{clientID}", cfg.middlewareRequireRole(auth.RoleAdmin, http.HandlerFunc(cfg.handleDeleteOAuthClient)))
+	mux.Handle("GET /admin/audit", cfg.middlewareRequireRole(auth.RoleAdmin, http.HandlerFunc(cfg.handleListAuditEvents)))
 	mux.HandleFunc("GET /api/healthz", handleHealth)
+	mux.HandleFunc("GET /.well-known/jwks.json", cfg.handleJWKS)
 
 	mux.HandleFunc("POST /api/login", cfg.handleLogin)
+	mux.HandleFunc("POST /api/login/2fa", cfg.handleLoginMFA)
+	mux.HandleFunc("GET /api/login/oidc", cfg.handleOIDCLogin)
+	mux.HandleFunc("POST /api/login/oidc/callback", cfg.handleOIDCCallback)
 	mux.HandleFunc("POST /api/refresh", cfg.handleRefresh)
 	mux.HandleFunc("POST /api/revoke", cfg.handleRevoke)
 
+	mux.HandleFunc("POST /api/password-reset/request", cfg.handlePasswordResetRequest)
+	mux.HandleFunc("POST /api/password-reset/confirm", cfg.handlePasswordResetConfirm)
+
+	mux.HandleFunc("POST /api/2fa/setup", cfg.handleSetupTOTP)
+	mux.HandleFunc("POST /api/2fa/verify", cfg.handleVerifyTOTP)
+	mux.HandleFunc("POST /api/2fa/disable", cfg.handleDisableTOTP)
+
+	mux.HandleFunc("GET /api/sessions", cfg.handleListSessions)
+	mux.HandleFunc("DELETE /api/sessions/{sessionID}", cfg.handleDeleteSession)
+	mux.HandleFunc("POST /api/sessions/revoke-all", cfg.handleRevokeAllSessions)
+
+	mux.HandleFunc("GET /oauth/authorize", cfg.handleAuthorize)
+	mux.HandleFunc("POST /oauth/authorize", cfg.handleAuthorizeDecision)
+	mux.HandleFunc("GET /oauth/clients/{clientID}", cfg.handleGetOAuthClient)
+	mux.HandleFunc("POST /oauth/token", cfg.handleOAuthToken)
+	mux.HandleFunc("POST /oauth/introspect", cfg.handleOAuthIntrospect)
+	mux.HandleFunc("POST /oauth/revoke", cfg.handleOAuthRevoke)
+
+	mux.HandleFunc("POST /api/tokens", cfg.handleCreateToken)
+	mux.HandleFunc("GET /api/tokens", cfg.handleListTokens)
+	mux.HandleFunc("DELETE /api/tokens/{tokenID}", cfg.handleDeleteToken)
+
 	mux.HandleFunc("POST /api/chirps", cfg.handleCreateChirp)
 
 	mux.HandleFunc("GET /api/chirps", cfg.handleChirps)
+	mux.HandleFunc("GET /api/chirps/search", cfg.handleSearchChirps)
 	mux.HandleFunc("GET /api/chirps/{chirpID}", cfg.handleGetChirp)
+	mux.HandleFunc("PATCH /api/chirps/{chirpID}", cfg.handleEditChirp)
 	mux.HandleFunc("DELETE /api/chirps/{chirpID}", cfg.handleDeleteChirp)
+	mux.HandleFunc("GET /api/chirps/{chirpID}/history", cfg.handleChirpHistory)
+	mux.HandleFunc("GET /api/chirps/{chirpID}/thread", cfg.handleChirpThread)
+	mux.HandleFunc("GET /api/chirps/{chirpID}/likes", cfg.handleChirpLikes)
+	mux.HandleFunc("POST /api/chirps/{chirpID}/likes", cfg.handleLikeChirp)
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}/likes", cfg.handleUnlikeChirp)
+	mux.HandleFunc("POST /api/chirps/{chirpID}/rechirp", cfg.handleRechirp)
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}/rechirp", cfg.handleUndoRechirp)
 
 
 	mux.HandleFunc("POST /api/users", cfg.handleCreateUser)
 	mux.HandleFunc("PUT /api/users", cfg.handlePutUser)
+	mux.HandleFunc("PATCH /api/users", cfg.handleUpdateProfile)
+	mux.HandleFunc("GET /api/users/{handle}", cfg.handleGetProfile)
+	mux.HandleFunc("POST /api/users/verify-email", cfg.handleVerifyEmail)
+	mux.HandleFunc("POST /api/users/verify-email/resend", cfg.handleResendEmailVerification)
+	mux.HandleFunc("GET /api/users/{userID}/likes", cfg.handleUserLikes)
+	mux.HandleFunc("POST /api/users/{userID}/follow", cfg.handleFollowUser)
+	mux.HandleFunc("DELETE /api/users/{userID}/follow", cfg.handleUnfollowUser)
+	mux.HandleFunc("GET /api/users/{userID}/followers", cfg.handleFollowers)
+	mux.HandleFunc("GET /api/users/{userID}/following", cfg.handleFollowing)
+	mux.HandleFunc("GET /api/timeline", cfg.handleTimeline)
+	mux.HandleFunc("GET /api/stream", cfg.handleStream)
+	mux.HandleFunc("POST /api/users/{userID}/block", cfg.handleBlockUser)
+	mux.HandleFunc("DELETE /api/users/{userID}/block", cfg.handleUnblockUser)
+	mux.HandleFunc("POST /api/users/{userID}/mute", cfg.handleMuteUser)
+	mux.HandleFunc("DELETE /api/users/{userID}/mute", cfg.handleUnmuteUser)
+	mux.HandleFunc("GET /api/blocks", cfg.handleListBlocks)
+	mux.HandleFunc("GET /api/mutes", cfg.handleListMutes)
+	mux.HandleFunc("GET /api/muted-words", cfg.handleListMutedWords)
+	mux.HandleFunc("POST /api/muted-words", cfg.handleCreateMutedWord)
+	mux.HandleFunc("DELETE /api/muted-words/{wordID}", cfg.handleDeleteMutedWord)
+
+	mux.HandleFunc("POST /api/media", cfg.handleUploadMedia)
+	mux.HandleFunc("POST /api/users/avatar", cfg.handleUploadAvatar)
+	mux.HandleFunc("GET /media/{key...}", cfg.handleServeMedia)
 
 	mux.HandleFunc("POST /api/polka/webhooks", cfg.handlePolkaWebhook)
 
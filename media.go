@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/eefret/chirpy/internal/auth"
+	"github.com/eefret/chirpy/internal/blobstore"
+	"github.com/eefret/chirpy/internal/database"
+	"github.com/eefret/chirpy/internal/imaging"
+	"github.com/google/uuid"
+)
+
+const (
+	maxUploadSize     = 10 << 20
+	maxAttachments    = 4
+	maxAltTextLength  = 1000
+	largeImageSide    = 1600
+	thumbnailSide     = 400
+	avatarSide        = 400
+	mediaCacheControl = "public, max-age=31536000, immutable"
+	// unattachedMediaRetention is how long an upload is kept without being
+	// attached to a chirp.
+	unattachedMediaRetention = 24 * time.Hour
+)
+
+// Attachment is an image attached to a chirp.
+type Attachment struct {
+	ID           uuid.UUID `json:"id"`
+	URL          string    `json:"url"`
+	ThumbnailURL string    `json:"thumbnail_url"`
+	ContentType  string    `json:"content_type"`
+	Width        int32     `json:"width"`
+	Height       int32     `json:"height"`
+	AltText      string    `json:"alt_text"`
+}
+
+// Media is an uploaded image that has not necessarily been attached yet.
+type Media struct {
+	ID           uuid.UUID `json:"id"`
+	URL          string    `json:"url"`
+	ThumbnailURL string    `json:"thumbnail_url"`
+	ContentType  string    `json:"content_type"`
+	Width        int32     `json:"width"`
+	Height       int32     `json:"height"`
+}
+
+func (cfg *apiConfig) mediaFromDB(m database.Media) Media {
+	return Media{
+		ID:           m.ID,
+		URL:          cfg.mediaBaseURL + m.BlobKey,
+		ThumbnailURL: cfg.mediaBaseURL + m.ThumbnailKey,
+		ContentType:  m.ContentType,
+		Width:        m.Width,
+		Height:       m.Height,
+	}
+}
+
+// readUpload reads the image in the multipart field "file" and decodes it.
+// If that fails, the error response has been written.
+func readUpload(w http.ResponseWriter, r *http.Request) (img image.Image, contentType string, ok bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	file, _, err := r.FormFile("file")
+	var tooBig *http.MaxBytesError
+	if errors.As(err, &tooBig) {
+		respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Uploads are at most %d MiB", maxUploadSize>>20))
+		return nil, "", false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Expected an image in the multipart field \"file\"")
+		return nil, "", false
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Could not read upload")
+		return nil, "", false
+	}
+
+	// The type is taken from the content, never from what the client
+	// claims, so nothing but images can be served back from our origin.
+	if _, err := imaging.Sniff(data); err != nil {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Only JPEG, PNG and GIF images are supported")
+		return nil, "", false
+	}
+	img, contentType, err = imaging.Decode(data)
+	if errors.Is(err, imaging.ErrTooLarge) {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Image dimensions are too large")
+		return nil, "", false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Could not decode image")
+		return nil, "", false
+	}
+	return img, contentType, true
+}
+
+// storeVariant encodes img, which drops any metadata the upload carried,
+// and stores it under prefix with the extension of its type.
+func (cfg *apiConfig) storeVariant(r *http.Request, img image.Image, sourceType, prefix string) (key, contentType string, err error) {
+	data, contentType, err := imaging.Encode(img, sourceType)
+	if err != nil {
+		return "", "", err
+	}
+	key = prefix + ".png"
+	if contentType == "image/jpeg" {
+		key = prefix + ".jpg"
+	}
+	return key, contentType, cfg.blobs.Put(r.Context(), key, data, contentType)
+}
+
+// handleUploadMedia stores an image for attaching to chirps: a large
+// version and a thumbnail, both without EXIF metadata.
+func (cfg *apiConfig) handleUploadMedia(w http.ResponseWriter, r *http.Request) {
+	caller, ok := cfg.authenticate(w, r, auth.ScopeChirpsWrite)
+	if !ok {
+		return
+	}
+
+	img, contentType, ok := readUpload(w, r)
+	if !ok {
+		return
+	}
+
+	prefix := "media/" + uuid.NewString() + "/"
+	large := imaging.Fit(img, largeImageSide, largeImageSide)
+	largeKey, storedType, err := cfg.storeVariant(r, large, contentType, prefix+"large")
+	if err != nil {
+		fmt.Println("Error storing image:", err)
+		respondWithError(w, http.StatusInternalServerError, "Could not store image")
+		return
+	}
+	small := imaging.Fit(img, thumbnailSide, thumbnailSide)
+	smallKey, _, err := cfg.storeVariant(r, small, contentType, prefix+"small")
+	if err != nil {
+		fmt.Println("Error storing image:", err)
+		respondWithError(w, http.StatusInternalServerError, "Could not store image")
+		return
+	}
+
+	bounds := large.Bounds()
+	m, err := cfg.DB.CreateMedia(r.Context(), database.CreateMediaParams{
+		UserID:       caller.UserID,
+		ContentType:  storedType,
+		Width:        int32(bounds.Dx()),
+		Height:       int32(bounds.Dy()),
+		BlobKey:      largeKey,
+		ThumbnailKey: smallKey,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not store image")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, cfg.mediaFromDB(m))
+}
+
+// handleUploadAvatar replaces the caller's avatar with a square crop of
+// the uploaded image.
+func (cfg *apiConfig) handleUploadAvatar(w http.ResponseWriter, r *http.Request) {
+	caller, ok := cfg.authenticate(w, r, auth.ScopeProfileWrite)
+	if !ok {
+		return
+	}
+
+	img, contentType, ok := readUpload(w, r)
+	if !ok {
+		return
+	}
+
+	u, err := cfg.DB.GetUser(r.Context(), caller.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	// A new key for every avatar lets clients cache them forever.
+	avatar := imaging.Square(img, avatarSide)
+	key, _, err := cfg.storeVariant(r, avatar, contentType, "avatars/"+u.ID.String()+"/"+uuid.NewString())
+	if err != nil {
+		fmt.Println("Error storing avatar:", err)
+		respondWithError(w, http.StatusInternalServerError, "Could not store avatar")
+		return
+	}
+
+	updated, err := cfg.DB.UpdateUserProfile(r.Context(), database.UpdateUserProfileParams{
+		ID:          u.ID,
+		Handle:      u.Handle,
+		DisplayName: u.DisplayName,
+		Bio:         u.Bio,
+		AvatarUrl:   cfg.mediaBaseURL + key,
+		Website:     u.Website,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not update profile")
+		return
+	}
+
+	// Only avatars we stored are ours to delete; an avatar_url set by hand
+	// can point anywhere.
+	if old, ok := strings.CutPrefix(u.AvatarUrl, cfg.mediaBaseURL); ok && strings.HasPrefix(old, "avatars/"+u.ID.String()+"/") {
+		if err := cfg.blobs.Delete(r.Context(), old); err != nil {
+			fmt.Println("Error deleting old avatar:", err)
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, userFromDB(updated))
+}
+
+// deleteMediaBlobs deletes both sizes of media whose rows are gone. A blob
+// that cannot be deleted is no longer linked from anywhere, so the error is
+// only logged.
+func (cfg *apiConfig) deleteMediaBlobs(ctx context.Context, media []database.Media) {
+	for _, m := range media {
+		for _, key := range []string{m.BlobKey, m.ThumbnailKey} {
+			if err := cfg.blobs.Delete(ctx, key); err != nil {
+				fmt.Println("Error deleting media blob:", err)
+			}
+		}
+	}
+}
+
+// pruneOrphanedMedia periodically deletes uploads that were never attached
+// to a chirp, or whose chirps are gone.
+func (cfg *apiConfig) pruneOrphanedMedia(every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		removed, err := cfg.DB.DeleteOrphanedMedia(context.Background(), now.Add(-unattachedMediaRetention))
+		if err != nil {
+			fmt.Println("Error pruning media:", err)
+			continue
+		}
+		cfg.deleteMediaBlobs(context.Background(), removed)
+	}
+}
+
+// handleServeMedia serves stored blobs when they are not served straight
+// from the object store.
+func (cfg *apiConfig) handleServeMedia(w http.ResponseWriter, r *http.Request) {
+	blob, contentType, err := cfg.blobs.Get(r.Context(), r.PathValue("key"))
+	if errors.Is(err, blobstore.ErrNotFound) || errors.Is(err, blobstore.ErrInvalidKey) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		fmt.Println("Error reading blob:", err)
+		respondWithError(w, http.StatusInternalServerError, "Could not read media")
+		return
+	}
+	defer blob.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", mediaCacheControl)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	io.Copy(w, blob)
+}
+
+// attachmentRequest is an image to attach to a new chirp.
+type attachmentRequest struct {
+	MediaID uuid.UUID `json:"media_id"`
+	AltText string    `json:"alt_text"`
+}
+
+// validateAttachments checks the attachments of a new chirp, which must be
+// the caller's own uploads. Its errors are meant for the client.
+func (cfg *apiConfig) validateAttachments(r *http.Request, q *database.Queries, userID uuid.UUID, attachments []attachmentRequest) error {
+	if len(attachments) > maxAttachments {
+		return fmt.Errorf("Chirps have at most %d attachments", maxAttachments)
+	}
+	seen := make(map[uuid.UUID]bool, len(attachments))
+	for _, a := range attachments {
+		if utf8.RuneCountInString(a.AltText) > maxAltTextLength {
+			return fmt.Errorf("Alt text is at most %d characters", maxAltTextLength)
+		}
+		if seen[a.MediaID] {
+			return errors.New("The same image is attached twice")
+		}
+		seen[a.MediaID] = true
+		m, err := q.GetMedia(r.Context(), a.MediaID)
+		if err != nil || m.UserID != userID {
+			return fmt.Errorf("Media %s not found", a.MediaID)
+		}
+	}
+	return nil
+}
+
+// setAttachments fills in the attachments of chirps.
+func (cfg *apiConfig) setAttachments(r *http.Request, chirps ...*Chirp) {
+	if len(chirps) == 0 {
+		return
+	}
+
+	ids := make([]uuid.UUID, 0, len(chirps))
+	for _, c := range chirps {
+		ids = append(ids, c.ID)
+	}
+	rows, err := cfg.DB.ListChirpAttachments(r.Context(), ids)
+	if err != nil {
+		// The chirps are still worth returning without them.
+		fmt.Println("Error listing chirp attachments:", err)
+		return
+	}
+
+	byChirp := make(map[uuid.UUID][]Attachment, len(rows))
+	for _, row := range rows {
+		m := cfg.mediaFromDB(row.Media)
+		byChirp[row.ChirpID] = append(byChirp[row.ChirpID], Attachment{
+			ID:           m.ID,
+			URL:          m.URL,
+			ThumbnailURL: m.ThumbnailURL,
+			ContentType:  m.ContentType,
+			Width:        m.Width,
+			Height:       m.Height,
+			AltText:      row.AltText,
+		})
+	}
+	for _, c := range chirps {
+		if attachments, ok := byChirp[c.ID]; ok {
+			c.Attachments = attachments
+		}
+	}
+}
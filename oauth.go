@@ -0,0 +1,766 @@
+package main
+
+import (
+	"crypto/hmac"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/eefret/chirpy/internal/auth"
+	"github.com/eefret/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// authorizationCodeTTL is how long a client has to exchange an
+// authorization code after the user approved it.
+const authorizationCodeTTL = time.Minute
+
+// consentTokenTTL is how long the user has to answer the consent page after
+// logging in on it.
+const consentTokenTTL = 10 * time.Minute
+
+// OAuthClient is a third-party application registered to act on behalf of
+// users. The secret is only returned when a confidential client is created.
+type OAuthClient struct {
+	ID           uuid.UUID `json:"client_id"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	Confidential bool      `json:"confidential"`
+	CreatedAt    time.Time `json:"created_at"`
+	Secret       string    `json:"client_secret,omitempty"`
+}
+
+func oauthClientFromDB(c database.OauthClient) OAuthClient {
+	return OAuthClient{
+		ID:           c.ID,
+		Name:         c.Name,
+		RedirectURIs: c.RedirectUris,
+		Scopes:       c.Scopes,
+		Confidential: c.SecretHash.Valid,
+		CreatedAt:    c.CreatedAt,
+	}
+}
+
+// oauthError is an error reported to OAuth clients in the form described in
+// RFC 6749 section 5.2.
+type oauthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *oauthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+func respondWithOAuthError(w http.ResponseWriter, code int, err *oauthError) {
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, code, err)
+}
+
+// validRedirectURI accepts absolute https URLs, and http URLs on the
+// loopback interface for native apps (RFC 8252).
+func validRedirectURI(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Fragment != "" || u.Host == "" {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	}
+	return false
+}
+
+// handleCreateOAuthClient registers a client. Confidential clients get a
+// secret, which is only shown in this response; public clients such as
+// mobile apps have none and rely on PKCE alone.
+func (cfg *apiConfig) handleCreateOAuthClient(w http.ResponseWriter, r *http.Request) {
+	type CreateClientRequest struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Scopes       []string `json:"scopes"`
+		Confidential bool     `json:"confidential"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	request := CreateClientRequest{}
+
+	err := decoder.Decode(&request)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if request.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "Name is required")
+		return
+	}
+
+	if len(request.RedirectURIs) == 0 {
+		respondWithError(w, http.StatusBadRequest, "At least one redirect URI is required")
+		return
+	}
+	for _, uri := range request.RedirectURIs {
+		if !validRedirectURI(uri) {
+			respondWithError(w, http.StatusBadRequest, "Invalid redirect URI: "+uri)
+			return
+		}
+	}
+
+	if len(request.Scopes) == 0 {
+		respondWithError(w, http.StatusBadRequest, "At least one scope is required")
+		return
+	}
+	for _, scope := range request.Scopes {
+		if !auth.ValidScope(scope) {
+			respondWithError(w, http.StatusBadRequest, "Unknown scope: "+scope)
+			return
+		}
+	}
+
+	var secret string
+	var secretHash sql.NullString
+	if request.Confidential {
+		secret, err = auth.MakeRefreshToken()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Could not generate client secret")
+			return
+		}
+		secretHash = sql.NullString{String: auth.HashToken(secret, cfg.tokenPepper), Valid: true}
+	}
+
+	c, err := cfg.DB.CreateOAuthClient(r.Context(), database.CreateOAuthClientParams{
+		Name:         request.Name,
+		SecretHash:   secretHash,
+		RedirectUris: request.RedirectURIs,
+		Scopes:       request.Scopes,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not create client")
+		return
+	}
+
+	cfg.audit(r, auditEvent{
+		Actor:      adminActor(r),
+		Action:     auditOAuthClientCreated,
+		TargetType: "oauth_client",
+		TargetID:   c.ID.String(),
+		Metadata:   map[string]any{"name": c.Name, "redirect_uris": c.RedirectUris, "scopes": c.Scopes},
+	})
+
+	client := oauthClientFromDB(c)
+	client.Secret = secret
+
+	respondWithJSON(w, http.StatusCreated, client)
+}
+
+func (cfg *apiConfig) handleListOAuthClients(w http.ResponseWriter, r *http.Request) {
+	clients, err := cfg.DB.ListOAuthClients(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not list clients")
+		return
+	}
+
+	response := []OAuthClient{}
+	for _, c := range clients {
+		response = append(response, oauthClientFromDB(c))
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// handleDeleteOAuthClient removes a client along with every token it holds.
+func (cfg *apiConfig) handleDeleteOAuthClient(w http.ResponseWriter, r *http.Request) {
+	clientID, err := uuid.Parse(r.PathValue("clientID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid client ID")
+		return
+	}
+
+	deleted, err := cfg.DB.DeleteOAuthClient(r.Context(), clientID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not delete client")
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, http.StatusNotFound, "Client not found")
+		return
+	}
+
+	cfg.audit(r, auditEvent{
+		Actor:      adminActor(r),
+		Action:     auditOAuthClientDeleted,
+		TargetType: "oauth_client",
+		TargetID:   clientID.String(),
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleGetOAuthClient returns what the consent page shows about a client.
+func (cfg *apiConfig) handleGetOAuthClient(w http.ResponseWriter, r *http.Request) {
+	clientID, err := uuid.Parse(r.PathValue("clientID"))
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Client not found")
+		return
+	}
+
+	c, err := cfg.DB.GetOAuthClient(r.Context(), clientID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Client not found")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, struct {
+		ID   uuid.UUID `json:"client_id"`
+		Name string    `json:"name"`
+	}{
+		ID:   c.ID,
+		Name: c.Name,
+	})
+}
+
+// authorizationRequest holds the parameters of an authorization request,
+// sent as a query string to GET /oauth/authorize and passed on as JSON by
+// the consent page.
+type authorizationRequest struct {
+	ResponseType        string `json:"response_type"`
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+}
+
+// errInvalidRedirect means the client or redirect URI of an authorization
+// request is unknown. The user must not be sent to such a redirect URI, so
+// the error is shown to the user instead of the client.
+var errInvalidRedirect = errors.New("unknown client or redirect URI")
+
+// checkAuthorizationRequest validates an authorization request and returns
+// the client and the requested scopes. It returns errInvalidRedirect or an
+// *oauthError that can be sent to the redirect URI.
+func (cfg *apiConfig) checkAuthorizationRequest(r *http.Request, req authorizationRequest) (database.OauthClient, []string, error) {
+	clientID, err := uuid.Parse(req.ClientID)
+	if err != nil {
+		return database.OauthClient{}, nil, errInvalidRedirect
+	}
+
+	client, err := cfg.DB.GetOAuthClient(r.Context(), clientID)
+	if err != nil {
+		return database.OauthClient{}, nil, errInvalidRedirect
+	}
+
+	// Redirect URIs must match a registered one exactly.
+	if !slices.Contains(client.RedirectUris, req.RedirectURI) {
+		return database.OauthClient{}, nil, errInvalidRedirect
+	}
+
+	if req.ResponseType != "code" {
+		return client, nil, &oauthError{"unsupported_response_type", "Only the authorization code flow is supported"}
+	}
+
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != "S256" {
+		return client, nil, &oauthError{"invalid_request", "PKCE with code_challenge_method S256 is required"}
+	}
+
+	scopes := strings.Fields(req.Scope)
+	if len(scopes) == 0 {
+		return client, nil, &oauthError{"invalid_scope", "At least one scope is required"}
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(client.Scopes, scope) {
+			return client, nil, &oauthError{"invalid_scope", "The client may not request " + scope}
+		}
+	}
+
+	return client, scopes, nil
+}
+
+// authorizationRedirect builds the URL the user is sent back to. The iss
+// parameter lets clients that talk to several servers detect mix-up
+// attacks (RFC 9207).
+func (cfg *apiConfig) authorizationRedirect(redirectURI, state string, params url.Values) string {
+	u, _ := url.Parse(redirectURI)
+	query := u.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	if state != "" {
+		query.Set("state", state)
+	}
+	query.Set("iss", cfg.publicURL)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func (cfg *apiConfig) authorizationErrorRedirect(req authorizationRequest, err *oauthError) string {
+	params := url.Values{"error": {err.Code}}
+	if err.Description != "" {
+		params.Set("error_description", err.Description)
+	}
+	return cfg.authorizationRedirect(req.RedirectURI, req.State, params)
+}
+
+// handleAuthorize is where clients send users to ask for access. Valid
+// requests are passed on to the consent page, which logs the user in and
+// submits the decision to handleAuthorizeDecision.
+func (cfg *apiConfig) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	req := authorizationRequest{
+		ResponseType:        query.Get("response_type"),
+		ClientID:            query.Get("client_id"),
+		RedirectURI:         query.Get("redirect_uri"),
+		Scope:               query.Get("scope"),
+		State:               query.Get("state"),
+		CodeChallenge:       query.Get("code_challenge"),
+		CodeChallengeMethod: query.Get("code_challenge_method"),
+	}
+
+	_, _, err := cfg.checkAuthorizationRequest(r, req)
+	var oerr *oauthError
+	if errors.As(err, &oerr) {
+		http.Redirect(w, r, cfg.authorizationErrorRedirect(req, oerr), http.StatusFound)
+		return
+	}
+	if err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, &oauthError{"invalid_request", "Unknown client or redirect URI"})
+		return
+	}
+
+	http.Redirect(w, r, "/app/oauth/consent.html?"+r.URL.RawQuery, http.StatusFound)
+}
+
+// respondWithConsentToken answers a login made on the consent page. The
+// page only needs to answer one authorization request, so instead of
+// starting a session it gets a token that is good for nothing else.
+func (cfg *apiConfig) respondWithConsentToken(w http.ResponseWriter, r *http.Request, u database.User, method string) {
+	consentToken, err := auth.MakeConsentToken(u.ID, cfg.jwtKeys, consentTokenTTL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not get consent token")
+		return
+	}
+
+	cfg.audit(r, auditEvent{
+		Actor:      u.ID,
+		Action:     auditLoginSucceeded,
+		TargetType: "user",
+		TargetID:   u.ID.String(),
+		Metadata:   map[string]any{"method": method, "oauth_consent": true},
+	})
+
+	respondWithJSON(w, http.StatusOK, struct {
+		ConsentToken string `json:"consent_token"`
+	}{
+		ConsentToken: consentToken,
+	})
+}
+
+// handleAuthorizeDecision records the user's answer on the consent page.
+// It needs a consent token from a login on that page, so neither sessions,
+// personal access tokens nor other OAuth clients can grant access. The
+// response tells the page where to send the user: back to the client with
+// an authorization code or an error.
+func (cfg *apiConfig) handleAuthorizeDecision(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	userID, err := auth.ValidateConsentToken(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid consent token")
+		return
+	}
+	if _, err := cfg.DB.GetUser(r.Context(), userID); err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid consent token")
+		return
+	}
+
+	type AuthorizeDecisionRequest struct {
+		authorizationRequest
+		Approve bool `json:"approve"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	request := AuthorizeDecisionRequest{}
+
+	err = decoder.Decode(&request)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	req := request.authorizationRequest
+
+	type AuthorizeDecisionResponse struct {
+		RedirectTo string `json:"redirect_to"`
+	}
+
+	client, scopes, err := cfg.checkAuthorizationRequest(r, req)
+	var oerr *oauthError
+	if errors.As(err, &oerr) {
+		respondWithJSON(w, http.StatusOK, AuthorizeDecisionResponse{RedirectTo: cfg.authorizationErrorRedirect(req, oerr)})
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unknown client or redirect URI")
+		return
+	}
+
+	if !request.Approve {
+		cfg.audit(r, auditEvent{
+			Actor:      userID,
+			Action:     auditOAuthDenied,
+			TargetType: "oauth_client",
+			TargetID:   client.ID.String(),
+			Metadata:   map[string]any{"scopes": scopes},
+		})
+		respondWithJSON(w, http.StatusOK, AuthorizeDecisionResponse{
+			RedirectTo: cfg.authorizationErrorRedirect(req, &oauthError{"access_denied", "The user denied access"}),
+		})
+		return
+	}
+
+	code, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not generate authorization code")
+		return
+	}
+
+	familyID := uuid.New()
+	err = cfg.DB.CreateAuthorizationCode(r.Context(), database.CreateAuthorizationCodeParams{
+		CodeHash:      auth.HashToken(code, cfg.tokenPepper),
+		ClientID:      client.ID,
+		UserID:        userID,
+		RedirectUri:   req.RedirectURI,
+		Scopes:        scopes,
+		CodeChallenge: req.CodeChallenge,
+		FamilyID:      familyID,
+		ExpiresAt:     time.Now().Add(authorizationCodeTTL),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not save authorization code")
+		return
+	}
+
+	cfg.audit(r, auditEvent{
+		Actor:      userID,
+		Action:     auditOAuthGranted,
+		TargetType: "oauth_client",
+		TargetID:   client.ID.String(),
+		Metadata:   map[string]any{"scopes": scopes, "session_id": familyID},
+	})
+
+	respondWithJSON(w, http.StatusOK, AuthorizeDecisionResponse{
+		RedirectTo: cfg.authorizationRedirect(req.RedirectURI, req.State, url.Values{"code": {code}}),
+	})
+}
+
+// authenticateClient identifies the client calling the token, introspection
+// or revocation endpoint. Confidential clients send their secret with HTTP
+// Basic authentication or as the client_secret form field; public clients
+// only send client_id.
+func (cfg *apiConfig) authenticateClient(r *http.Request) (database.OauthClient, *oauthError) {
+	invalid := &oauthError{"invalid_client", "Client authentication failed"}
+
+	id, secret, basic := r.BasicAuth()
+	if basic {
+		// RFC 6749 has the credentials form-encoded before they are put in the header.
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		id = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	clientID, err := uuid.Parse(id)
+	if err != nil {
+		return database.OauthClient{}, invalid
+	}
+
+	client, err := cfg.DB.GetOAuthClient(r.Context(), clientID)
+	if err != nil {
+		return database.OauthClient{}, invalid
+	}
+
+	if client.SecretHash.Valid {
+		hash := auth.HashToken(secret, cfg.tokenPepper)
+		if secret == "" || !hmac.Equal([]byte(hash), []byte(client.SecretHash.String)) {
+			return database.OauthClient{}, invalid
+		}
+	} else if secret != "" {
+		return database.OauthClient{}, invalid
+	}
+
+	return client, nil
+}
+
+// handleOAuthToken exchanges an authorization code or a refresh token for
+// tokens (RFC 6749 section 3.2).
+func (cfg *apiConfig) handleOAuthToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, &oauthError{"invalid_request", "Invalid form body"})
+		return
+	}
+
+	client, oerr := cfg.authenticateClient(r)
+	if oerr != nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+		respondWithOAuthError(w, http.StatusUnauthorized, oerr)
+		return
+	}
+
+	var session database.RefreshToken
+	var refreshToken string
+	var err error
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		session, err = cfg.exchangeAuthorizationCode(r, client)
+		if err == nil {
+			refreshToken, err = cfg.issueRefreshToken(r, cfg.DB, session)
+		}
+	case "refresh_token":
+		session, refreshToken, err = cfg.refreshOAuthToken(r, client)
+	default:
+		err = &oauthError{"unsupported_grant_type", "Use authorization_code or refresh_token"}
+	}
+	if errors.As(err, &oerr) {
+		respondWithOAuthError(w, http.StatusBadRequest, oerr)
+		return
+	}
+	if err != nil {
+		fmt.Println("Error issuing OAuth tokens:", err)
+		respondWithOAuthError(w, http.StatusInternalServerError, &oauthError{"server_error", ""})
+		return
+	}
+
+	accessToken, err := auth.MakeScopedJWT(session.UserID, client.ID, session.Scopes, cfg.jwtKeys, accessTokenTTL)
+	if err != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, &oauthError{"server_error", ""})
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusOK, struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int    `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+		Scope        string `json:"scope"`
+	}{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        strings.Join(session.Scopes, " "),
+	})
+}
+
+// exchangeAuthorizationCode redeems an authorization code and returns the
+// session it starts. A code can only be redeemed once; if it shows up again
+// it was intercepted, so the tokens issued for it are revoked. The client,
+// redirect URI and code verifier are checked before the code is marked
+// used, so a request that fails them cannot spend someone else's code.
+func (cfg *apiConfig) exchangeAuthorizationCode(r *http.Request, client database.OauthClient) (database.RefreshToken, error) {
+	invalid := &oauthError{"invalid_grant", "Invalid authorization code"}
+
+	codeHash := auth.HashToken(r.PostForm.Get("code"), cfg.tokenPepper)
+	code, err := cfg.DB.GetAuthorizationCode(r.Context(), codeHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.RefreshToken{}, invalid
+	}
+	if err != nil {
+		return database.RefreshToken{}, err
+	}
+	if code.UsedAt.Valid {
+		return database.RefreshToken{}, cfg.revokeReusedCode(r, code)
+	}
+
+	if !code.ExpiresAt.After(time.Now()) || code.ClientID != client.ID || code.RedirectUri != r.PostForm.Get("redirect_uri") {
+		return database.RefreshToken{}, invalid
+	}
+	if !auth.VerifyPKCE(r.PostForm.Get("code_verifier"), code.CodeChallenge) {
+		return database.RefreshToken{}, &oauthError{"invalid_grant", "Invalid code_verifier"}
+	}
+
+	// Only one of several requests racing with the same code gets a row.
+	_, err = cfg.DB.ConsumeAuthorizationCode(r.Context(), codeHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.RefreshToken{}, cfg.revokeReusedCode(r, code)
+	}
+	if err != nil {
+		return database.RefreshToken{}, err
+	}
+
+	return database.RefreshToken{
+		UserID:           code.UserID,
+		FamilyID:         code.FamilyID,
+		SessionStartedAt: time.Now(),
+		ClientID:         uuid.NullUUID{UUID: client.ID, Valid: true},
+		Scopes:           code.Scopes,
+	}, nil
+}
+
+// revokeReusedCode revokes the tokens issued for an authorization code that
+// was presented again, and returns the error to answer with.
+func (cfg *apiConfig) revokeReusedCode(r *http.Request, code database.OauthAuthorizationCode) error {
+	if err := cfg.DB.RevokeRefreshTokenFamily(r.Context(), code.FamilyID); err != nil {
+		return err
+	}
+	cfg.audit(r, auditEvent{
+		Action:     auditOAuthCodeReused,
+		TargetType: "session",
+		TargetID:   code.FamilyID.String(),
+		Metadata:   map[string]any{"user_id": code.UserID, "client_id": code.ClientID},
+	})
+	return &oauthError{"invalid_grant", "Invalid authorization code"}
+}
+
+// refreshOAuthToken rotates a client's refresh token like /api/refresh
+// does. The client may ask for fewer scopes than it was granted.
+func (cfg *apiConfig) refreshOAuthToken(r *http.Request, client database.OauthClient) (database.RefreshToken, string, error) {
+	invalid := &oauthError{"invalid_grant", "Invalid refresh token"}
+
+	stored, err := cfg.DB.GetRefreshToken(r.Context(), auth.HashToken(r.PostForm.Get("refresh_token"), cfg.tokenPepper))
+	if err != nil {
+		return database.RefreshToken{}, "", invalid
+	}
+
+	if !stored.ClientID.Valid || stored.ClientID.UUID != client.ID || !stored.ExpiresAt.After(time.Now()) {
+		return database.RefreshToken{}, "", invalid
+	}
+
+	if scope := r.PostForm.Get("scope"); scope != "" {
+		scopes := strings.Fields(scope)
+		for _, s := range scopes {
+			if !slices.Contains(stored.Scopes, s) {
+				return database.RefreshToken{}, "", &oauthError{"invalid_scope", "The grant does not include " + s}
+			}
+		}
+		stored.Scopes = scopes
+	}
+
+	refreshToken, err := cfg.rotateRefreshToken(r, stored)
+	if errors.Is(err, errRefreshTokenReused) {
+		return database.RefreshToken{}, "", invalid
+	}
+	if err != nil {
+		return database.RefreshToken{}, "", err
+	}
+
+	return stored, refreshToken, nil
+}
+
+// handleOAuthIntrospect tells a confidential client whether a token it
+// holds is active (RFC 7662). Tokens issued to other clients, and
+// first-party tokens, are reported as inactive.
+func (cfg *apiConfig) handleOAuthIntrospect(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, &oauthError{"invalid_request", "Invalid form body"})
+		return
+	}
+
+	client, oerr := cfg.authenticateClient(r)
+	if oerr != nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+		respondWithOAuthError(w, http.StatusUnauthorized, oerr)
+		return
+	}
+	// Public clients cannot prove who they are, so anyone could use their
+	// client_id to probe tokens.
+	if !client.SecretHash.Valid {
+		respondWithOAuthError(w, http.StatusUnauthorized, &oauthError{"invalid_client", "Only confidential clients may introspect tokens"})
+		return
+	}
+
+	type IntrospectionResponse struct {
+		Active    bool   `json:"active"`
+		Scope     string `json:"scope,omitempty"`
+		ClientID  string `json:"client_id,omitempty"`
+		Subject   string `json:"sub,omitempty"`
+		TokenType string `json:"token_type,omitempty"`
+		ExpiresAt int64  `json:"exp,omitempty"`
+		IssuedAt  int64  `json:"iat,omitempty"`
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	token := r.PostForm.Get("token")
+
+	if claims, err := auth.ValidateJWTClaims(token, cfg.jwtKeys); err == nil {
+		if claims.ClientID != client.ID.String() {
+			respondWithJSON(w, http.StatusOK, IntrospectionResponse{Active: false})
+			return
+		}
+		respondWithJSON(w, http.StatusOK, IntrospectionResponse{
+			Active:    true,
+			Scope:     claims.Scope,
+			ClientID:  claims.ClientID,
+			Subject:   claims.Subject,
+			TokenType: "access_token",
+			ExpiresAt: claims.ExpiresAt.Unix(),
+			IssuedAt:  claims.IssuedAt.Unix(),
+		})
+		return
+	}
+
+	stored, err := cfg.DB.GetRefreshToken(r.Context(), auth.HashToken(token, cfg.tokenPepper))
+	if err != nil || stored.RevokedAt.Valid || !stored.ExpiresAt.After(time.Now()) ||
+		!stored.ClientID.Valid || stored.ClientID.UUID != client.ID {
+		respondWithJSON(w, http.StatusOK, IntrospectionResponse{Active: false})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, IntrospectionResponse{
+		Active:    true,
+		Scope:     strings.Join(stored.Scopes, " "),
+		ClientID:  client.ID.String(),
+		Subject:   stored.UserID.String(),
+		TokenType: "refresh_token",
+		ExpiresAt: stored.ExpiresAt.Unix(),
+		IssuedAt:  stored.CreatedAt.Time.Unix(),
+	})
+}
+
+// handleOAuthRevoke lets a client give up a grant (RFC 7009). Revoking a
+// refresh token revokes the whole grant. Access tokens cannot be revoked
+// and simply expire. As the RFC asks, unknown tokens are not an error.
+func (cfg *apiConfig) handleOAuthRevoke(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, &oauthError{"invalid_request", "Invalid form body"})
+		return
+	}
+
+	client, oerr := cfg.authenticateClient(r)
+	if oerr != nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+		respondWithOAuthError(w, http.StatusUnauthorized, oerr)
+		return
+	}
+
+	stored, err := cfg.DB.GetRefreshToken(r.Context(), auth.HashToken(r.PostForm.Get("token"), cfg.tokenPepper))
+	if err == nil && stored.ClientID.Valid && stored.ClientID.UUID == client.ID {
+		if err := cfg.DB.RevokeRefreshTokenFamily(r.Context(), stored.FamilyID); err != nil {
+			respondWithOAuthError(w, http.StatusServiceUnavailable, &oauthError{"temporarily_unavailable", ""})
+			return
+		}
+		cfg.audit(r, auditEvent{
+			Action:     auditOAuthRevoked,
+			TargetType: "session",
+			TargetID:   stored.FamilyID.String(),
+			Metadata:   map[string]any{"user_id": stored.UserID, "client_id": client.ID},
+		})
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
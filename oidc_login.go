@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/eefret/chirpy/internal/auth"
+	"github.com/eefret/chirpy/internal/database"
+	"github.com/eefret/chirpy/internal/oidc"
+)
+
+const (
+	oidcCookieName = "chirpy_oidc"
+	oidcCookiePath = "/api/login/oidc"
+	// oidcLoginTTL is how long the user has to log in at the provider.
+	oidcLoginTTL = 10 * time.Minute
+)
+
+var (
+	errIdentityEmailUnverified = errors.New("the provider has not verified the email address")
+	errLocalEmailUnverified    = errors.New("the matching account has not verified its email address")
+)
+
+// handleOIDCLogin sends the user to the identity provider. The state, nonce
+// and PKCE verifier are kept in a short-lived cookie that only the callback
+// endpoint receives.
+func (cfg *apiConfig) handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if cfg.oidc == nil {
+		respondWithError(w, http.StatusNotFound, "Single sign-on is not configured")
+		return
+	}
+
+	var values [3]string
+	for i := range values {
+		v, err := oidc.RandomString()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+			return
+		}
+		values[i] = v
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	authURL, err := cfg.oidc.AuthCodeURL(r.Context(), state, nonce, oidc.CodeChallenge(verifier))
+	if err != nil {
+		fmt.Println("Error starting OIDC login:", err)
+		respondWithError(w, http.StatusBadGateway, "Could not reach the identity provider")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookieName,
+		Value:    strings.Join(values[:], "."),
+		Path:     oidcCookiePath,
+		MaxAge:   int(oidcLoginTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(cfg.publicURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// handleOIDCCallback finishes a login at the identity provider. The
+// provider redirects the user to /app/oidc-callback.html, which posts the
+// code and state here. The response is the same as for POST /api/login.
+func (cfg *apiConfig) handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if cfg.oidc == nil {
+		respondWithError(w, http.StatusNotFound, "Single sign-on is not configured")
+		return
+	}
+
+	type OIDCCallbackRequest struct {
+		Code  string `json:"code"`
+		State string `json:"state"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	request := OIDCCallbackRequest{}
+
+	err := decoder.Decode(&request)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	cookie, err := r.Cookie(oidcCookieName)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Login session expired, please start again")
+		return
+	}
+	// The values are single use.
+	http.SetCookie(w, &http.Cookie{Name: oidcCookieName, Path: oidcCookiePath, MaxAge: -1})
+
+	values := strings.Split(cookie.Value, ".")
+	if len(values) != 3 || subtle.ConstantTimeCompare([]byte(values[0]), []byte(request.State)) != 1 {
+		respondWithError(w, http.StatusBadRequest, "Login session expired, please start again")
+		return
+	}
+	nonce, verifier := values[1], values[2]
+
+	tokens, err := cfg.oidc.Exchange(r.Context(), request.Code, verifier)
+	if err != nil {
+		fmt.Println("Error exchanging OIDC code:", err)
+		respondWithError(w, http.StatusUnauthorized, "Could not log in with the identity provider")
+		return
+	}
+
+	idToken, err := cfg.oidc.VerifyIDToken(r.Context(), tokens.IDToken, nonce)
+	if err != nil {
+		fmt.Println("Error verifying OIDC ID token:", err)
+		respondWithError(w, http.StatusUnauthorized, "Could not log in with the identity provider")
+		return
+	}
+
+	u, err := cfg.userForIdentity(r, idToken)
+	switch {
+	case errors.Is(err, errIdentityEmailUnverified):
+		respondWithError(w, http.StatusForbidden, "Your identity provider has not verified your email address")
+		return
+	case errors.Is(err, errLocalEmailUnverified):
+		respondWithError(w, http.StatusConflict, "An account with this email exists; verify its email address before using single sign-on")
+		return
+	case err != nil:
+		respondWithError(w, http.StatusInternalServerError, "Could not log in")
+		return
+	}
+
+	if u.TotpEnabledAt.Valid {
+		cfg.respondWithMFAChallenge(w, u)
+		return
+	}
+
+	cfg.completeLogin(w, r, u, "oidc")
+}
+
+// userForIdentity finds the user linked to a provider identity. The first
+// time an identity logs in it is linked to the account with the same email,
+// or a new account is created. Linking needs the email to be verified on
+// both sides; otherwise whoever registered an address first, on either side,
+// could take over the other account.
+func (cfg *apiConfig) userForIdentity(r *http.Request, idToken *oidc.IDToken) (database.User, error) {
+	ctx := r.Context()
+	issuer := cfg.oidc.Issuer()
+
+	u, err := cfg.DB.GetUserByIdentity(ctx, database.GetUserByIdentityParams{
+		Issuer:  issuer,
+		Subject: idToken.Subject,
+	})
+	if err == nil {
+		err := cfg.DB.TouchUserIdentity(ctx, database.TouchUserIdentityParams{
+			Issuer:  issuer,
+			Subject: idToken.Subject,
+			Email:   idToken.Email,
+		})
+		if err != nil {
+			fmt.Println("Error updating identity:", err)
+		}
+		return u, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, err
+	}
+
+	if idToken.Email == "" || !idToken.EmailVerified {
+		return database.User{}, errIdentityEmailUnverified
+	}
+
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return database.User{}, err
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	created := false
+	u, err = qtx.GetUserByEmail(ctx, idToken.Email)
+	switch {
+	case err == nil:
+		if !u.EmailVerifiedAt.Valid {
+			return database.User{}, errLocalEmailUnverified
+		}
+	case errors.Is(err, sql.ErrNoRows):
+		u, err = cfg.createSSOUser(ctx, qtx, idToken.Email)
+		if err != nil {
+			return database.User{}, err
+		}
+		created = true
+	default:
+		return database.User{}, err
+	}
+
+	err = qtx.CreateUserIdentity(ctx, database.CreateUserIdentityParams{
+		Issuer:  issuer,
+		Subject: idToken.Subject,
+		UserID:  u.ID,
+		Email:   idToken.Email,
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return database.User{}, err
+	}
+
+	if created {
+		cfg.audit(r, auditEvent{
+			Actor:      u.ID,
+			Action:     auditUserCreated,
+			TargetType: "user",
+			TargetID:   u.ID.String(),
+			Metadata:   map[string]any{"method": "oidc"},
+		})
+	}
+	cfg.audit(r, auditEvent{
+		Actor:      u.ID,
+		Action:     auditIdentityLinked,
+		TargetType: "user",
+		TargetID:   u.ID.String(),
+		Metadata:   map[string]any{"issuer": issuer, "subject": idToken.Subject},
+	})
+
+	return u, nil
+}
+
+// createSSOUser creates an account for someone who first logs in through
+// the identity provider. Its password is random, so nobody knows it until
+// the user sets one through a password reset.
+func (cfg *apiConfig) createSSOUser(ctx context.Context, q *database.Queries, email string) (database.User, error) {
+	password, err := auth.MakeRefreshToken()
+	if err != nil {
+		return database.User{}, err
+	}
+	hashedPassword, err := auth.HashPassword(password)
+	if err != nil {
+		return database.User{}, err
+	}
+
+	u, err := q.CreateUser(ctx, database.CreateUserParams{
+		Email:          email,
+		HashedPassword: hashedPassword,
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+
+	// The provider has already verified the address.
+	return q.VerifyUserEmail(ctx, database.VerifyUserEmailParams{
+		ID:    u.ID,
+		Email: email,
+	})
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/eefret/chirpy/internal/auth"
+	"github.com/eefret/chirpy/internal/database"
+	"github.com/eefret/chirpy/internal/mailer"
+)
+
+const passwordResetTTL = time.Hour
+
+// handlePasswordResetRequest emails a single-use reset link. It answers the
+// same way whether or not the email belongs to an account, so it cannot be
+// used to find out who is registered.
+func (cfg *apiConfig) handlePasswordResetRequest(w http.ResponseWriter, r *http.Request) {
+	type PasswordResetRequest struct {
+		Email string `json:"email"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	request := PasswordResetRequest{}
+
+	err := decoder.Decode(&request)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if request.Email == "" {
+		respondWithError(w, http.StatusBadRequest, "Email is required")
+		return
+	}
+
+	u, err := cfg.DB.GetUserByEmail(r.Context(), request.Email)
+	if err != nil {
+		cfg.audit(r, auditEvent{
+			Action:   auditPasswordResetRequested,
+			Metadata: map[string]any{"email": request.Email},
+		})
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	cfg.audit(r, auditEvent{
+		Action:     auditPasswordResetRequested,
+		TargetType: "user",
+		TargetID:   u.ID.String(),
+		Metadata:   map[string]any{"email": request.Email},
+	})
+
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not generate reset token")
+		return
+	}
+
+	err = cfg.DB.CreatePasswordResetToken(r.Context(), database.CreatePasswordResetTokenParams{
+		TokenHash: auth.HashToken(token, cfg.tokenPepper),
+		UserID:    u.ID,
+		ExpiresAt: time.Now().Add(passwordResetTTL),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not save reset token")
+		return
+	}
+
+	// Deliver in the background so response time does not reveal whether the account exists.
+	msg := mailer.Message{
+		To:      u.Email,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf("Someone asked to reset the password for your Chirpy account.\n\n"+
+			"Choose a new password here within the next hour:\n%s/app/reset-password.html?token=%s\n\n"+
+			"If this wasn't you, you can ignore this email.\n",
+			cfg.publicURL, url.QueryEscape(token)),
+	}
+	go func() {
+		if err := cfg.mailer.Send(context.Background(), msg); err != nil {
+			fmt.Println("Error sending password reset email:", err)
+		}
+	}()
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handlePasswordResetConfirm sets a new password using a reset token and
+// logs the account out everywhere.
+func (cfg *apiConfig) handlePasswordResetConfirm(w http.ResponseWriter, r *http.Request) {
+	type PasswordResetConfirmRequest struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	request := PasswordResetConfirmRequest{}
+
+	err := decoder.Decode(&request)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if request.Token == "" || request.Password == "" {
+		respondWithError(w, http.StatusBadRequest, "Token and Password are required")
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(request.Password)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not reset password")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	userID, err := qtx.ConsumePasswordResetToken(r.Context(), auth.HashToken(request.Token, cfg.tokenPepper))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired reset token")
+		return
+	}
+
+	_, err = qtx.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
+		ID:             userID,
+		HashedPassword: hashedPassword,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not reset password")
+		return
+	}
+
+	if err := qtx.InvalidatePasswordResetTokens(r.Context(), userID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not reset password")
+		return
+	}
+
+	if err := qtx.RevokeAllSessions(r.Context(), userID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not revoke sessions")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not reset password")
+		return
+	}
+
+	cfg.audit(r, auditEvent{
+		Actor:      userID,
+		Action:     auditPasswordReset,
+		TargetType: "user",
+		TargetID:   userID.String(),
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/eefret/chirpy/internal/auth"
+	"github.com/eefret/chirpy/internal/database"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const (
+	maxDisplayNameLength = 50
+	maxBioLength         = 160
+	maxProfileURLLength  = 200
+)
+
+// handlePattern is what a handle may contain. Handles can never look like
+// a user ID, so GET /api/users/{handle} takes either.
+var handlePattern = regexp.MustCompile(`^[A-Za-z0-9_]{3,30}$`)
+
+// Profile is what anyone can see about a user. It must never carry the
+// user's email or anything else from their account settings.
+type Profile struct {
+	ID             uuid.UUID `json:"id"`
+	Handle         *string   `json:"handle"`
+	DisplayName    string    `json:"display_name"`
+	Bio            string    `json:"bio"`
+	AvatarURL      string    `json:"avatar_url"`
+	Website        string    `json:"website"`
+	IsRed          bool      `json:"is_chirpy_red"`
+	CreatedAt      time.Time `json:"created_at"`
+	ChirpCount     int64     `json:"chirp_count"`
+	FollowerCount  int32     `json:"follower_count"`
+	FollowingCount int32     `json:"following_count"`
+}
+
+func profileFromDB(u database.User, chirpCount int64) Profile {
+	return Profile{
+		ID:             u.ID,
+		Handle:         nullStringPtr(u.Handle),
+		DisplayName:    u.DisplayName,
+		Bio:            u.Bio,
+		AvatarURL:      u.AvatarUrl,
+		Website:        u.Website,
+		IsRed:          u.IsRed,
+		CreatedAt:      u.CreatedAt.Time,
+		ChirpCount:     chirpCount,
+		FollowerCount:  u.FollowerCount,
+		FollowingCount: u.FollowingCount,
+	}
+}
+
+func nullStringPtr(s sql.NullString) *string {
+	if !s.Valid {
+		return nil
+	}
+	return &s.String
+}
+
+// validateHandle checks a handle chosen by a user.
+func validateHandle(handle string) error {
+	if !handlePattern.MatchString(handle) {
+		return errors.New("Handles must be 3 to 30 letters, digits or underscores")
+	}
+	return nil
+}
+
+// validateProfileURL checks a link shown on a profile. Empty clears it.
+func validateProfileURL(field, raw string) error {
+	if raw == "" {
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || len(raw) > maxProfileURLLength {
+		return fmt.Errorf("%s must be an http or https URL of at most %d characters", field, maxProfileURLLength)
+	}
+	return nil
+}
+
+// isHandleTaken reports whether err is a write losing the race for a
+// handle to another user.
+func isHandleTaken(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "users_handle_key"
+}
+
+// handleUpdateProfile changes the caller's public profile. Fields left out
+// of the request stay as they are, and an empty string clears a field.
+func (cfg *apiConfig) handleUpdateProfile(w http.ResponseWriter, r *http.Request) {
+	caller, ok := cfg.authenticate(w, r, auth.ScopeProfileWrite)
+	if !ok {
+		return
+	}
+
+	type UpdateProfileRequest struct {
+		Handle      *string `json:"handle"`
+		DisplayName *string `json:"display_name"`
+		Bio         *string `json:"bio"`
+		AvatarURL   *string `json:"avatar_url"`
+		Website     *string `json:"website"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	request := UpdateProfileRequest{}
+
+	err := decoder.Decode(&request)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	u, err := cfg.DB.GetUser(r.Context(), caller.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	params := database.UpdateUserProfileParams{
+		ID:          u.ID,
+		Handle:      u.Handle,
+		DisplayName: u.DisplayName,
+		Bio:         u.Bio,
+		AvatarUrl:   u.AvatarUrl,
+		Website:     u.Website,
+	}
+
+	if request.Handle != nil {
+		// Once chosen a handle can be changed but not removed, so links to
+		// the profile keep working until the user picks another.
+		if err := validateHandle(*request.Handle); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		params.Handle = sql.NullString{String: *request.Handle, Valid: true}
+	}
+	if request.DisplayName != nil {
+		params.DisplayName = strings.TrimSpace(*request.DisplayName)
+		if utf8.RuneCountInString(params.DisplayName) > maxDisplayNameLength {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Display names are at most %d characters", maxDisplayNameLength))
+			return
+		}
+	}
+	if request.Bio != nil {
+		params.Bio = strings.TrimSpace(*request.Bio)
+		if utf8.RuneCountInString(params.Bio) > maxBioLength {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Bios are at most %d characters", maxBioLength))
+			return
+		}
+	}
+	if request.AvatarURL != nil {
+		if err := validateProfileURL("avatar_url", *request.AvatarURL); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		params.AvatarUrl = *request.AvatarURL
+	}
+	if request.Website != nil {
+		if err := validateProfileURL("website", *request.Website); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		params.Website = *request.Website
+	}
+
+	u, err = cfg.DB.UpdateUserProfile(r.Context(), params)
+	if isHandleTaken(err) {
+		respondWithError(w, http.StatusConflict, "Handle is already taken")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not update profile")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, userFromDB(u))
+}
+
+// handleGetProfile returns the public profile of a user, found by handle,
+// in any case, or by ID.
+func (cfg *apiConfig) handleGetProfile(w http.ResponseWriter, r *http.Request) {
+	handle := r.PathValue("handle")
+
+	var u database.User
+	var err error
+	if id, parseErr := uuid.Parse(handle); parseErr == nil {
+		u, err = cfg.DB.GetUser(r.Context(), id)
+	} else if validateHandle(handle) == nil {
+		u, err = cfg.DB.GetUserByHandle(r.Context(), handle)
+	} else {
+		err = sql.ErrNoRows
+	}
+	if err != nil || cfg.blocked(r.Context(), cfg.viewer(r), u.ID) {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	chirpCount, err := cfg.DB.CountChirpsByAuthor(r.Context(), u.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not load profile")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, profileFromDB(u, chirpCount))
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/eefret/chirpy/internal/auth"
+	"github.com/eefret/chirpy/internal/database"
+	"github.com/eefret/chirpy/internal/events"
+	"github.com/google/uuid"
+)
+
+// originalChirp returns a chirp that can be replied to, quoted, rechirped
+// or liked. Those actions on a rechirp go to the chirp it reposts, and
+// tombstones are treated as missing.
+func (cfg *apiConfig) originalChirp(ctx context.Context, id uuid.UUID) (database.Chirp, error) {
+	chirp, err := cfg.DB.GetChirp(ctx, id)
+	if err == nil && chirp.RechirpOf.Valid {
+		chirp, err = cfg.DB.GetChirp(ctx, chirp.RechirpOf.UUID)
+	}
+	if err != nil {
+		return database.Chirp{}, err
+	}
+	if chirp.DeletedAt.Valid {
+		return database.Chirp{}, sql.ErrNoRows
+	}
+	return chirp, nil
+}
+
+// fillChirps adds what chirps in a response need beyond their own rows: the
+// chirps they rechirp or quote, their attachments, and whether the user
+// making the request has liked them. Rechirped and quoted chirps hidden
+// from that user are left out.
+func (cfg *apiConfig) fillChirps(r *http.Request, chirps ...*Chirp) {
+	viewer := cfg.viewer(r)
+	all := chirps
+	// A rechirp of a quote chirp shows the quoted chirp too, which takes a
+	// second round.
+	pending := chirps
+	for round := 0; round < 2 && len(pending) > 0; round++ {
+		var ids []uuid.UUID
+		for _, c := range pending {
+			if c.rechirpOfID.Valid {
+				ids = append(ids, c.rechirpOfID.UUID)
+			}
+			if c.quoteOfID.Valid {
+				ids = append(ids, c.quoteOfID.UUID)
+			}
+		}
+		if len(ids) == 0 {
+			break
+		}
+
+		embedded, err := cfg.DB.GetChirpsByIDs(r.Context(), database.GetChirpsByIDsParams{
+			Ids:      ids,
+			ViewerID: nullViewer(viewer),
+		})
+		if err != nil {
+			fmt.Println("Error loading rechirped and quoted chirps:", err)
+			break
+		}
+		byID := make(map[uuid.UUID]database.Chirp, len(embedded))
+		for _, c := range embedded {
+			byID[c.ID] = c
+		}
+
+		var next []*Chirp
+		for _, c := range pending {
+			if original, ok := byID[c.rechirpOfID.UUID]; ok && c.rechirpOfID.Valid {
+				e := chirpFromDB(original)
+				c.RechirpOf = &e
+				next = append(next, c.RechirpOf)
+			}
+			if quoted, ok := byID[c.quoteOfID.UUID]; ok && c.quoteOfID.Valid {
+				e := chirpFromDB(quoted)
+				c.QuotedChirp = &e
+				next = append(next, c.QuotedChirp)
+			}
+		}
+		all = append(all, next...)
+		pending = next
+	}
+
+	cfg.setLikedByMe(r, viewer, all...)
+	cfg.setAttachments(r, all...)
+}
+
+// handleRechirp reposts a chirp. Rechirping a chirp again returns the
+// existing rechirp.
+func (cfg *apiConfig) handleRechirp(w http.ResponseWriter, r *http.Request) {
+	caller, ok := cfg.authenticate(w, r, auth.ScopeChirpsWrite)
+	if !ok {
+		return
+	}
+
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	if !cfg.checkCanPost(w, r, caller.UserID) {
+		return
+	}
+
+	original, err := cfg.originalChirp(r.Context(), chirpID)
+	if err != nil || cfg.blocked(r.Context(), caller.UserID, original.UserID) {
+		respondWithError(w, http.StatusNotFound, "Chirp not found")
+		return
+	}
+
+	status := http.StatusCreated
+	rechirpOf := uuid.NullUUID{UUID: original.ID, Valid: true}
+	rechirp, err := cfg.DB.CreateRechirp(r.Context(), database.CreateRechirpParams{
+		UserID:    caller.UserID,
+		RechirpOf: rechirpOf,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		status = http.StatusOK
+		rechirp, err = cfg.DB.GetRechirp(r.Context(), database.GetRechirpParams{
+			UserID:    caller.UserID,
+			RechirpOf: rechirpOf,
+		})
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not rechirp")
+		return
+	}
+
+	if status == http.StatusCreated {
+		if err := cfg.DB.FanOutChirp(r.Context(), rechirp.ID); err != nil {
+			fmt.Println("Error adding rechirp to timelines:", err)
+		}
+		cfg.recordChirpEvent(r, events.ChirpCreated, rechirp)
+	}
+
+	response := chirpFromDB(rechirp)
+	cfg.fillChirps(r, &response)
+	respondWithJSON(w, status, response)
+}
+
+// handleUndoRechirp removes the caller's rechirp of a chirp, if any.
+func (cfg *apiConfig) handleUndoRechirp(w http.ResponseWriter, r *http.Request) {
+	caller, ok := cfg.authenticate(w, r, auth.ScopeChirpsWrite)
+	if !ok {
+		return
+	}
+
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	if chirp, err := cfg.DB.GetChirp(r.Context(), chirpID); err == nil && chirp.RechirpOf.Valid {
+		chirpID = chirp.RechirpOf.UUID
+	}
+
+	rechirpOf := uuid.NullUUID{UUID: chirpID, Valid: true}
+	rechirp, err := cfg.DB.GetRechirp(r.Context(), database.GetRechirpParams{
+		UserID:    caller.UserID,
+		RechirpOf: rechirpOf,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not undo rechirp")
+		return
+	}
+
+	deleted, err := cfg.DB.DeleteRechirp(r.Context(), database.DeleteRechirpParams{
+		UserID:    caller.UserID,
+		RechirpOf: rechirpOf,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not undo rechirp")
+		return
+	}
+	if deleted > 0 {
+		cfg.recordChirpEvent(r, events.ChirpDeleted, rechirp)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
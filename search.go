@@ -0,0 +1,131 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/eefret/chirpy/internal/database"
+	"github.com/eefret/chirpy/internal/pagination"
+	"github.com/eefret/chirpy/internal/tsquery"
+	"github.com/google/uuid"
+)
+
+type SearchResult struct {
+	Chirp
+	Rank float32 `json:"rank"`
+	// Snippet is HTML: the chirp text, escaped, with matches in <mark> tags.
+	Snippet string `json:"snippet"`
+}
+
+// snippetHighlighter turns the markers SearchChirps puts around matches
+// into <mark> tags. The text is escaped first, so chirps cannot inject HTML.
+var snippetHighlighter = strings.NewReplacer("\ue000", "<mark>", "\ue001", "</mark>")
+
+func highlightSnippet(snippet string) string {
+	return snippetHighlighter.Replace(html.EscapeString(snippet))
+}
+
+// handleSearchChirps searches chirp text, most relevant first. It pages like
+// GET /api/chirps with limit and cursor.
+func (cfg *apiConfig) handleSearchChirps(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	tsq, err := tsquery.Parse(query.Get("q"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid search: "+err.Error())
+		return
+	}
+
+	params := database.SearchChirpsParams{Query: tsq}
+
+	if auid := query.Get("author_id"); auid != "" {
+		id, err := uuid.Parse(auid)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid author ID")
+			return
+		}
+		params.AuthorID = uuid.NullUUID{UUID: id, Valid: true}
+	}
+
+	for _, bound := range []struct {
+		name string
+		dst  *sql.NullTime
+	}{{"since", &params.Since}, {"until", &params.Until}} {
+		v := query.Get(bound.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, bound.name+" must be an RFC 3339 time")
+			return
+		}
+		*bound.dst = sql.NullTime{Time: t, Valid: true}
+	}
+
+	limit, err := pagination.ParseLimit(query.Get("limit"), defaultChirpPageSize, maxChirpPageSize)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxChirpPageSize))
+		return
+	}
+	page := pageRequest{Limit: limit}
+	params.MaxRows = page.maxRows()
+	params.ViewerID = nullViewer(cfg.viewer(r))
+
+	if c := query.Get("cursor"); c != "" {
+		cursor, err := pagination.DecodeRankCursor(c)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid cursor")
+			return
+		}
+		params.AfterRank = sql.NullFloat64{Float64: float64(cursor.Rank), Valid: true}
+		params.AfterID = uuid.NullUUID{UUID: cursor.ID, Valid: true}
+	}
+
+	rows, err := cfg.DB.SearchChirps(r.Context(), params)
+	if err != nil {
+		fmt.Println("Error searching chirps:", err)
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	type SearchPage struct {
+		Chirps     []SearchResult `json:"chirps"`
+		NextCursor *string        `json:"next_cursor"`
+	}
+
+	response := SearchPage{Chirps: []SearchResult{}}
+	rows, response.NextCursor = nextPage(cfg, w, r, page, rows, func(last database.SearchChirpsRow) string {
+		return pagination.RankCursor{Rank: last.Rank, ID: last.ID}.Encode()
+	})
+	for _, row := range rows {
+		response.Chirps = append(response.Chirps, SearchResult{
+			Chirp: chirpFromDB(database.Chirp{
+				ID:             row.ID,
+				CreatedAt:      row.CreatedAt,
+				UpdatedAt:      row.UpdatedAt,
+				UserID:         row.UserID,
+				Body:           row.Body,
+				InReplyTo:      row.InReplyTo,
+				ConversationID: row.ConversationID,
+				ReplyCount:     row.ReplyCount,
+				LikeCount:      row.LikeCount,
+				QuoteOf:        row.QuoteOf,
+			}),
+			Rank:    row.Rank,
+			Snippet: highlightSnippet(row.Snippet),
+		})
+	}
+
+	chirps := make([]*Chirp, 0, len(response.Chirps))
+	for i := range response.Chirps {
+		chirps = append(chirps, &response.Chirps[i].Chirp)
+	}
+	cfg.fillChirps(r, chirps...)
+
+	respondWithJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/eefret/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// Session is a logged in device. Its ID is the refresh token family, which
+// stays the same while the refresh token itself is rotated.
+type Session struct {
+	ID         uuid.UUID `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	// ClientID is set when the session is an OAuth client's access grant.
+	ClientID *uuid.UUID `json:"client_id,omitempty"`
+}
+
+func (cfg *apiConfig) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	caller, ok := cfg.authenticate(w, r, "")
+	if !ok {
+		return
+	}
+	id := caller.UserID
+
+	tokens, err := cfg.DB.ListSessions(r.Context(), id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not list sessions")
+		return
+	}
+
+	sessions := []Session{}
+	for _, token := range tokens {
+		session := Session{
+			ID:         token.FamilyID,
+			UserAgent:  token.UserAgent,
+			IP:         token.Ip,
+			CreatedAt:  token.SessionStartedAt,
+			LastUsedAt: token.LastUsedAt,
+			ExpiresAt:  token.ExpiresAt,
+		}
+		if token.ClientID.Valid {
+			session.ClientID = &token.ClientID.UUID
+		}
+		sessions = append(sessions, session)
+	}
+
+	respondWithJSON(w, http.StatusOK, sessions)
+}
+
+func (cfg *apiConfig) handleDeleteSession(w http.ResponseWriter, r *http.Request) {
+	caller, ok := cfg.authenticate(w, r, "")
+	if !ok {
+		return
+	}
+	id := caller.UserID
+
+	sessionID, err := uuid.Parse(r.PathValue("sessionID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid session ID")
+		return
+	}
+
+	revoked, err := cfg.DB.RevokeSession(r.Context(), database.RevokeSessionParams{
+		FamilyID: sessionID,
+		UserID:   id,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not revoke session")
+		return
+	}
+	if revoked == 0 {
+		respondWithError(w, http.StatusNotFound, "Session not found")
+		return
+	}
+
+	cfg.audit(r, auditEvent{
+		Actor:      id,
+		Action:     auditSessionRevoked,
+		TargetType: "session",
+		TargetID:   sessionID.String(),
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRevokeAllSessions logs the user out everywhere, including the session making the request.
+func (cfg *apiConfig) handleRevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	caller, ok := cfg.authenticate(w, r, "")
+	if !ok {
+		return
+	}
+	id := caller.UserID
+
+	if err := cfg.DB.RevokeAllSessions(r.Context(), id); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not revoke sessions")
+		return
+	}
+
+	cfg.audit(r, auditEvent{
+		Actor:      id,
+		Action:     auditSessionsRevoked,
+		TargetType: "user",
+		TargetID:   id.String(),
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
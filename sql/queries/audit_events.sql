@@ -0,0 +1,18 @@
+-- name: CreateAuditEvent :exec
+INSERT INTO audit_events (actor_id, action, target_type, target_id, ip, user_agent, metadata)
+VALUES ($1, $2, $3, $4, $5, $6, $7);
+
+-- name: ListAuditEvents :many
+-- Filters left NULL match every event. An action also matches the actions
+-- below it, so "login" matches "login.failed". Events come newest first;
+-- pass the last id of a page as before_id to get the next one.
+SELECT * FROM audit_events
+WHERE (sqlc.narg(actor_id)::uuid IS NULL OR actor_id = sqlc.narg(actor_id))
+AND (sqlc.narg(action)::text IS NULL OR action = sqlc.narg(action) OR action LIKE sqlc.narg(action) || '.%')
+AND (sqlc.narg(target_id)::text IS NULL OR target_id = sqlc.narg(target_id))
+AND (sqlc.narg(ip)::text IS NULL OR ip = sqlc.narg(ip))
+AND (sqlc.narg(since)::timestamptz IS NULL OR created_at >= sqlc.narg(since))
+AND (sqlc.narg(until)::timestamptz IS NULL OR created_at < sqlc.narg(until))
+AND (sqlc.narg(before_id)::bigint IS NULL OR id < sqlc.narg(before_id))
+ORDER BY id DESC
+LIMIT sqlc.arg(max_rows);
\ No newline at end of file
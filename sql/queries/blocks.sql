@@ -0,0 +1,21 @@
+-- name: CreateBlock :execrows
+-- Blocking a user again changes nothing and affects no rows.
+INSERT INTO blocks (blocker_id, blocked_id)
+VALUES ($1, $2)
+ON CONFLICT DO NOTHING;
+
+-- name: DeleteBlock :execrows
+DELETE FROM blocks WHERE blocker_id = $1 AND blocked_id = $2;
+
+-- name: IsBlocked :one
+-- Whether either user has blocked the other.
+SELECT blocked_between(sqlc.arg(user_a)::uuid, sqlc.arg(user_b)::uuid)::boolean AS blocked;
+
+-- name: ListBlocks :many
+-- The users a user has blocked, most recent first. Pass the created_at and
+-- blocked_id of the last block of a page to get the next one.
+SELECT * FROM blocks
+WHERE blocker_id = sqlc.arg(user_id)
+AND (sqlc.narg(before_created_at)::timestamptz IS NULL OR (created_at, blocked_id) < (sqlc.narg(before_created_at), sqlc.narg(before_id)::uuid))
+ORDER BY created_at DESC, blocked_id DESC
+LIMIT sqlc.arg(max_rows);
\ No newline at end of file
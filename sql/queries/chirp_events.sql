@@ -0,0 +1,27 @@
+-- name: CreateChirpEvent :one
+INSERT INTO chirp_events (type, chirp_id, user_id)
+VALUES ($1, $2, $3)
+RETURNING *;
+
+-- name: GetLastChirpEventID :one
+SELECT COALESCE(MAX(id), 0)::bigint AS last_id FROM chirp_events;
+
+-- name: ListChirpEventsAfter :many
+SELECT * FROM chirp_events
+WHERE id > sqlc.arg(after_id)
+ORDER BY id
+LIMIT sqlc.arg(max_rows);
+
+-- name: LockChirpEvents :exec
+-- Holds the chirp events lock until the surrounding transaction ends, so
+-- events get their ids in the order their transactions commit.
+SELECT pg_advisory_xact_lock(7283946501);
+
+-- name: NotifyChirpEvent :exec
+-- Sends payload to every server listening on the chirp_events channel once
+-- the surrounding transaction, if any, commits.
+SELECT pg_notify('chirp_events', sqlc.arg(payload)::text);
+
+-- name: PruneChirpEvents :exec
+DELETE FROM chirp_events
+WHERE created_at < $1;
\ No newline at end of file
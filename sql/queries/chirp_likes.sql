@@ -0,0 +1,52 @@
+-- name: LikeChirp :execrows
+-- Likes a chirp and counts the like in one statement, so concurrent likes
+-- cannot miscount. Liking a chirp again changes nothing and affects no
+-- rows.
+WITH liked AS (
+    INSERT INTO chirp_likes (user_id, chirp_id)
+    VALUES ($1, $2)
+    ON CONFLICT DO NOTHING
+    RETURNING chirp_id
+)
+UPDATE chirps SET like_count = like_count + 1
+WHERE id IN (SELECT chirp_id FROM liked);
+
+-- name: UnlikeChirp :execrows
+-- Like LikeChirp, for taking a like back.
+WITH unliked AS (
+    DELETE FROM chirp_likes
+    WHERE user_id = $1 AND chirp_id = $2
+    RETURNING chirp_id
+)
+UPDATE chirps SET like_count = like_count - 1
+WHERE id IN (SELECT chirp_id FROM unliked);
+
+-- name: ListChirpLikes :many
+-- The likes of a chirp, newest first. Pass the created_at and user_id of
+-- the last like of a page to get the next one. Likes by users who blocked
+-- or were blocked by viewer_id are left out.
+SELECT * FROM chirp_likes
+WHERE chirp_id = sqlc.arg(chirp_id)
+AND (sqlc.narg(before_created_at)::timestamptz IS NULL OR (created_at, user_id) < (sqlc.narg(before_created_at), sqlc.narg(before_user_id)::uuid))
+AND NOT blocked_between(sqlc.narg(viewer_id)::uuid, user_id)
+ORDER BY created_at DESC, user_id DESC
+LIMIT sqlc.arg(max_rows);
+
+-- name: ListLikedChirps :many
+-- The chirps a user has liked, most recently liked first. Pass the liked_at
+-- and id of the last chirp of a page to get the next one. Chirps hidden
+-- from viewer_id are left out.
+SELECT sqlc.embed(chirps), chirp_likes.created_at AS liked_at
+FROM chirp_likes
+JOIN chirps ON chirps.id = chirp_likes.chirp_id
+WHERE chirp_likes.user_id = sqlc.arg(user_id)
+AND chirps.deleted_at IS NULL
+AND (sqlc.narg(before_liked_at)::timestamptz IS NULL OR (chirp_likes.created_at, chirp_likes.chirp_id) < (sqlc.narg(before_liked_at), sqlc.narg(before_id)::uuid))
+AND NOT chirp_hidden_from(sqlc.narg(viewer_id)::uuid, chirps.user_id, chirps.body, chirps.rechirp_of)
+ORDER BY chirp_likes.created_at DESC, chirp_likes.chirp_id DESC
+LIMIT sqlc.arg(max_rows);
+
+-- name: ListLikedChirpIDs :many
+-- Which of chirp_ids the user has liked.
+SELECT chirp_id FROM chirp_likes
+WHERE user_id = sqlc.arg(user_id) AND chirp_id = ANY(sqlc.arg(chirp_ids)::uuid[]);
\ No newline at end of file
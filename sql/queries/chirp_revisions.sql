@@ -0,0 +1,11 @@
+-- name: CreateChirpRevision :exec
+INSERT INTO chirp_revisions (chirp_id, body, created_at)
+VALUES ($1, $2, $3);
+
+-- name: ListChirpRevisions :many
+SELECT * FROM chirp_revisions
+WHERE chirp_id = $1
+ORDER BY replaced_at DESC, id DESC;
+
+-- name: DeleteChirpRevisions :exec
+DELETE FROM chirp_revisions WHERE chirp_id = $1;
\ No newline at end of file
@@ -1,16 +1,159 @@
 -- name: CreateChirp :one
-INSERT INTO chirps (user_id, body)
-VALUES ($1, $2)
+-- A reply joins the conversation of the chirp it replies to.
+INSERT INTO chirps (user_id, body, in_reply_to, conversation_id, quote_of)
+VALUES (
+    sqlc.arg(user_id),
+    sqlc.arg(body),
+    sqlc.narg(in_reply_to),
+    (SELECT parent.conversation_id FROM chirps parent WHERE parent.id = sqlc.narg(in_reply_to)),
+    sqlc.narg(quote_of)
+)
 RETURNING *;
 
--- name: GetChirps :many
-SELECT * FROM chirps ORDER BY created_at ASC;
+-- name: ListChirpsAsc :many
+-- Chirps oldest first, optionally by one author. Pass the created_at and id
+-- of the last chirp of a page to get the next one. A NULL max_rows returns
+-- every chirp. Chirps hidden from viewer_id are left out.
+SELECT * FROM chirps
+WHERE deleted_at IS NULL
+AND (sqlc.narg(author_id)::uuid IS NULL OR user_id = sqlc.narg(author_id))
+AND (sqlc.narg(after_created_at)::timestamptz IS NULL OR (created_at, id) > (sqlc.narg(after_created_at), sqlc.narg(after_id)::uuid))
+AND NOT chirp_hidden_from(sqlc.narg(viewer_id)::uuid, user_id, body, rechirp_of)
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.narg(max_rows);
 
--- name: GetChirpsByAuthor :many
-SELECT * FROM chirps WHERE user_id = $1 ORDER BY created_at ASC;
+-- name: ListChirpsDesc :many
+-- Like ListChirpsAsc, newest first.
+SELECT * FROM chirps
+WHERE deleted_at IS NULL
+AND (sqlc.narg(author_id)::uuid IS NULL OR user_id = sqlc.narg(author_id))
+AND (sqlc.narg(before_created_at)::timestamptz IS NULL OR (created_at, id) < (sqlc.narg(before_created_at), sqlc.narg(before_id)::uuid))
+AND NOT chirp_hidden_from(sqlc.narg(viewer_id)::uuid, user_id, body, rechirp_of)
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.narg(max_rows);
+
+-- name: CountChirpsByAuthor :one
+-- Rechirps count too; tombstones do not.
+SELECT COUNT(*) FROM chirps WHERE user_id = $1 AND deleted_at IS NULL;
 
 -- name: GetChirp :one
 SELECT * FROM chirps WHERE id = $1;
 
+-- name: GetChirpsByIDs :many
+-- Chirps hidden from viewer_id are left out.
+SELECT * FROM chirps
+WHERE id = ANY(sqlc.arg(ids)::uuid[])
+AND NOT chirp_hidden_from(sqlc.narg(viewer_id)::uuid, user_id, body, rechirp_of);
+
+-- name: GetChirpForUpdate :one
+-- Locks the chirp until the transaction ends, so concurrent edits cannot
+-- lose a revision.
+SELECT * FROM chirps WHERE id = $1 FOR UPDATE;
+
+-- name: UpdateChirpBody :one
+UPDATE chirps
+SET body = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
 -- name: DeleteChirp :exec
-DELETE FROM chirps WHERE id = $1;
\ No newline at end of file
+DELETE FROM chirps WHERE id = $1;
+
+-- name: CreateRechirp :one
+-- Returns no rows if the user has already rechirped the chirp.
+INSERT INTO chirps (user_id, body, rechirp_of)
+VALUES ($1, '', $2)
+ON CONFLICT (rechirp_of, user_id) WHERE rechirp_of IS NOT NULL DO NOTHING
+RETURNING *;
+
+-- name: GetRechirp :one
+SELECT * FROM chirps WHERE user_id = $1 AND rechirp_of = $2;
+
+-- name: DeleteRechirp :execrows
+DELETE FROM chirps WHERE user_id = $1 AND rechirp_of = $2;
+
+-- name: DeleteRechirpsOf :many
+DELETE FROM chirps WHERE rechirp_of = $1
+RETURNING *;
+
+-- name: TombstoneChirp :exec
+-- Clears a deleted chirp that has replies, keeping its place in the thread.
+UPDATE chirps
+SET body = '', deleted_at = NOW()
+WHERE id = $1;
+
+-- name: IncrementReplyCount :exec
+UPDATE chirps SET reply_count = reply_count + 1 WHERE id = $1;
+
+-- name: DecrementReplyCount :exec
+UPDATE chirps SET reply_count = reply_count - 1 WHERE id = $1 AND reply_count > 0;
+
+-- name: ListChirpAncestors :many
+-- The chirps a chirp replies to, up to the start of the conversation,
+-- root first. Chirps hidden from viewer_id are left out.
+WITH RECURSIVE ancestors AS (
+    SELECT parent.id, parent.in_reply_to, 1 AS depth
+    FROM chirps child
+    JOIN chirps parent ON parent.id = child.in_reply_to
+    WHERE child.id = sqlc.arg(id)
+    UNION ALL
+    SELECT chirps.id, chirps.in_reply_to, ancestors.depth + 1
+    FROM chirps
+    JOIN ancestors ON chirps.id = ancestors.in_reply_to
+)
+SELECT chirps.* FROM chirps
+JOIN ancestors ON chirps.id = ancestors.id
+WHERE NOT chirp_hidden_from(sqlc.narg(viewer_id)::uuid, chirps.user_id, chirps.body, chirps.rechirp_of)
+ORDER BY ancestors.depth DESC;
+
+-- name: ListReplyTree :many
+-- A page of the direct replies to a chirp, oldest first, with their own
+-- replies down to max_depth levels. Below the first level at most
+-- max_children replies are returned per chirp. Pass the created_at and id
+-- of the last direct reply of a page to get the next one. Replies hidden
+-- from viewer_id are left out, along with the replies to them.
+WITH RECURSIVE tree AS (
+    (
+        SELECT chirps.id, 1 AS depth
+        FROM chirps
+        WHERE chirps.in_reply_to = sqlc.arg(parent_id)::uuid
+        AND (sqlc.narg(after_created_at)::timestamptz IS NULL OR (chirps.created_at, chirps.id) > (sqlc.narg(after_created_at), sqlc.narg(after_id)::uuid))
+        AND NOT chirp_hidden_from(sqlc.narg(viewer_id)::uuid, chirps.user_id, chirps.body, chirps.rechirp_of)
+        ORDER BY chirps.created_at ASC, chirps.id ASC
+        LIMIT sqlc.arg(max_rows)
+    )
+    UNION ALL
+    SELECT child.id, tree.depth + 1
+    FROM tree
+    CROSS JOIN LATERAL (
+        SELECT chirps.id FROM chirps
+        WHERE chirps.in_reply_to = tree.id
+        AND NOT chirp_hidden_from(sqlc.narg(viewer_id)::uuid, chirps.user_id, chirps.body, chirps.rechirp_of)
+        ORDER BY chirps.created_at ASC, chirps.id ASC
+        LIMIT sqlc.arg(max_children)
+    ) child
+    WHERE tree.depth < sqlc.arg(max_depth)::int
+)
+SELECT chirps.* FROM chirps
+JOIN tree ON chirps.id = tree.id
+ORDER BY chirps.created_at ASC, chirps.id ASC;
+
+-- name: SearchChirps :many
+-- Chirps matching a to_tsquery query, most relevant first, with the
+-- matches in snippet wrapped in U+E000 and U+E001. Pass the rank and id of
+-- the last result of a page to get the next one. Chirps hidden from
+-- viewer_id are left out.
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.user_id, chirps.body,
+    chirps.in_reply_to, chirps.conversation_id, chirps.reply_count, chirps.like_count, chirps.quote_of,
+    ts_rank_cd(chirps.search_vector, tsq) AS rank,
+    ts_headline('english', chirps.body, tsq, 'StartSel=' || chr(57344) || ', StopSel=' || chr(57345) || ', MaxFragments=2, MaxWords=20, MinWords=5')::text AS snippet
+FROM chirps, to_tsquery('english', sqlc.arg(query)) tsq
+WHERE chirps.search_vector @@ tsq
+AND chirps.deleted_at IS NULL
+AND (sqlc.narg(author_id)::uuid IS NULL OR chirps.user_id = sqlc.narg(author_id))
+AND (sqlc.narg(since)::timestamptz IS NULL OR chirps.created_at >= sqlc.narg(since))
+AND (sqlc.narg(until)::timestamptz IS NULL OR chirps.created_at < sqlc.narg(until))
+AND (sqlc.narg(after_rank)::real IS NULL OR (ts_rank_cd(chirps.search_vector, tsq), chirps.id) < (sqlc.narg(after_rank), sqlc.narg(after_id)::uuid))
+AND NOT chirp_hidden_from(sqlc.narg(viewer_id)::uuid, chirps.user_id, chirps.body, chirps.rechirp_of)
+ORDER BY rank DESC, chirps.id DESC
+LIMIT sqlc.arg(max_rows);
\ No newline at end of file
@@ -0,0 +1,54 @@
+-- name: FollowUser :execrows
+-- Follows a user and counts the follow on both sides in one statement, so
+-- concurrent follows cannot miscount. Following a user again changes
+-- nothing and affects no rows.
+WITH followed AS (
+    INSERT INTO follows (follower_id, followee_id)
+    VALUES ($1, $2)
+    ON CONFLICT DO NOTHING
+    RETURNING follower_id, followee_id
+), followee AS (
+    UPDATE users SET follower_count = follower_count + 1
+    WHERE id IN (SELECT followee_id FROM followed)
+)
+UPDATE users SET following_count = following_count + 1
+WHERE id IN (SELECT follower_id FROM followed);
+
+-- name: UnfollowUser :execrows
+-- Like FollowUser, for unfollowing.
+WITH unfollowed AS (
+    DELETE FROM follows
+    WHERE follower_id = $1 AND followee_id = $2
+    RETURNING follower_id, followee_id
+), followee AS (
+    UPDATE users SET follower_count = follower_count - 1
+    WHERE id IN (SELECT followee_id FROM unfollowed)
+)
+UPDATE users SET following_count = following_count - 1
+WHERE id IN (SELECT follower_id FROM unfollowed);
+
+-- name: MarkFanoutOnRead :exec
+UPDATE users SET fanout_on_read = true
+WHERE id = $1 AND follower_count >= $2;
+
+-- name: ListFollowers :many
+-- The followers of a user, most recent first. Pass the created_at and
+-- follower_id of the last follow of a page to get the next one.
+SELECT * FROM follows
+WHERE followee_id = sqlc.arg(user_id)
+AND (sqlc.narg(before_created_at)::timestamptz IS NULL OR (created_at, follower_id) < (sqlc.narg(before_created_at), sqlc.narg(before_id)::uuid))
+ORDER BY created_at DESC, follower_id DESC
+LIMIT sqlc.arg(max_rows);
+
+-- name: ListFollowing :many
+-- Like ListFollowers, for the users a user follows.
+SELECT * FROM follows
+WHERE follower_id = sqlc.arg(user_id)
+AND (sqlc.narg(before_created_at)::timestamptz IS NULL OR (created_at, followee_id) < (sqlc.narg(before_created_at), sqlc.narg(before_id)::uuid))
+ORDER BY created_at DESC, followee_id DESC
+LIMIT sqlc.arg(max_rows);
+
+-- name: ListFollowedIDs :many
+-- The ids of every user a user follows.
+SELECT followee_id FROM follows
+WHERE follower_id = $1;
\ No newline at end of file
@@ -0,0 +1,34 @@
+-- name: RecordLoginFailure :one
+-- Failures older than window_start are forgotten, so the count starts over.
+-- The row lock taken by the upsert makes concurrent failures count in turn.
+INSERT INTO login_attempts (key, failures, last_failure_at)
+VALUES (sqlc.arg(key), 1, sqlc.arg(failed_at))
+ON CONFLICT (key) DO UPDATE
+SET failures = CASE
+        WHEN login_attempts.last_failure_at < sqlc.arg(window_start) THEN 1
+        ELSE login_attempts.failures + 1
+    END,
+    previous_failure_at = CASE
+        WHEN login_attempts.last_failure_at < sqlc.arg(window_start) THEN NULL
+        ELSE login_attempts.last_failure_at
+    END,
+    last_failure_at = EXCLUDED.last_failure_at
+RETURNING *;
+
+-- name: GetLoginAttempts :one
+SELECT * FROM login_attempts
+WHERE key = $1;
+
+-- name: RemoveLoginFailure :exec
+-- Takes back one failure, for an attempt counted in advance that succeeded.
+UPDATE login_attempts
+SET failures = failures - 1
+WHERE key = $1 AND failures > 0;
+
+-- name: ClearLoginAttempts :exec
+DELETE FROM login_attempts
+WHERE key = $1;
+
+-- name: PruneLoginAttempts :exec
+DELETE FROM login_attempts
+WHERE last_failure_at < $1;
\ No newline at end of file
@@ -0,0 +1,38 @@
+-- name: CreateMedia :one
+INSERT INTO media (user_id, content_type, width, height, blob_key, thumbnail_key)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING *;
+
+-- name: GetMedia :one
+SELECT * FROM media WHERE id = $1;
+
+-- name: CreateChirpAttachment :exec
+INSERT INTO chirp_attachments (chirp_id, position, media_id, alt_text)
+VALUES ($1, $2, $3, $4);
+
+-- name: ListChirpAttachments :many
+-- The attachments of chirp_ids, in order within each chirp.
+SELECT chirp_attachments.chirp_id, chirp_attachments.position, chirp_attachments.alt_text, sqlc.embed(media)
+FROM chirp_attachments
+JOIN media ON media.id = chirp_attachments.media_id
+WHERE chirp_attachments.chirp_id = ANY(sqlc.arg(chirp_ids)::uuid[])
+ORDER BY chirp_attachments.chirp_id, chirp_attachments.position;
+
+-- name: DeleteChirpAttachments :exec
+DELETE FROM chirp_attachments WHERE chirp_id = $1;
+
+-- name: DeleteUnattachedMedia :many
+-- Deletes the media among ids that no chirp uses anymore, returning them so
+-- their blobs can be deleted too.
+DELETE FROM media
+WHERE id = ANY(sqlc.arg(ids)::uuid[])
+AND NOT EXISTS (SELECT 1 FROM chirp_attachments WHERE chirp_attachments.media_id = media.id)
+RETURNING *;
+
+-- name: DeleteOrphanedMedia :many
+-- Deletes uploads made before created_before that were never attached, or
+-- are not attached anymore.
+DELETE FROM media
+WHERE created_at < sqlc.arg(created_before)
+AND NOT EXISTS (SELECT 1 FROM chirp_attachments WHERE chirp_attachments.media_id = media.id)
+RETURNING *;
\ No newline at end of file
@@ -0,0 +1,32 @@
+-- name: CreateMute :execrows
+-- Muting a user again changes nothing and affects no rows.
+INSERT INTO mutes (muter_id, muted_id)
+VALUES ($1, $2)
+ON CONFLICT DO NOTHING;
+
+-- name: DeleteMute :execrows
+DELETE FROM mutes WHERE muter_id = $1 AND muted_id = $2;
+
+-- name: ListMutes :many
+-- Like ListBlocks, for the users a user has muted.
+SELECT * FROM mutes
+WHERE muter_id = sqlc.arg(user_id)
+AND (sqlc.narg(before_created_at)::timestamptz IS NULL OR (created_at, muted_id) < (sqlc.narg(before_created_at), sqlc.narg(before_id)::uuid))
+ORDER BY created_at DESC, muted_id DESC
+LIMIT sqlc.arg(max_rows);
+
+-- name: CreateMutedWord :one
+-- Returns no rows if the user has already muted the word.
+INSERT INTO muted_words (user_id, word)
+VALUES ($1, $2)
+ON CONFLICT (user_id, word) DO NOTHING
+RETURNING *;
+
+-- name: ListMutedWords :many
+SELECT * FROM muted_words WHERE user_id = $1 ORDER BY word ASC;
+
+-- name: CountMutedWords :one
+SELECT COUNT(*) FROM muted_words WHERE user_id = $1;
+
+-- name: DeleteMutedWord :execrows
+DELETE FROM muted_words WHERE id = $1 AND user_id = $2;
\ No newline at end of file
@@ -1,14 +1,57 @@
 -- name: SaveRefreshToken :one
-INSERT INTO refresh_tokens (user_id, token, expires_at)
-VALUES ($1, $2, $3)
+INSERT INTO refresh_tokens (user_id, token_hash, expires_at, family_id, user_agent, ip, session_started_at, client_id, scopes)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 RETURNING *;
 
 -- name: GetRefreshToken :one
 SELECT * FROM refresh_tokens 
-WHERE token = $1 
+WHERE token_hash = $1 
 LIMIT 1;
 
 -- name: RevokeRefreshToken :exec
 UPDATE refresh_tokens
 SET revoked_at = NOW(), updated_at = NOW()
-WHERE token = $1;
\ No newline at end of file
+WHERE token_hash = $1;
+
+-- name: ConsumeRefreshToken :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE token_hash = $1
+AND revoked_at IS NULL
+AND expires_at > NOW();
+
+-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE family_id = $1
+AND revoked_at IS NULL;
+
+-- name: ListLegacyRefreshTokens :many
+SELECT token_hash FROM refresh_tokens
+WHERE NOT hashed;
+
+-- name: HashLegacyRefreshToken :exec
+UPDATE refresh_tokens
+SET token_hash = sqlc.arg(new_hash), hashed = TRUE
+WHERE token_hash = sqlc.arg(old_token)
+AND NOT hashed;
+
+-- name: ListSessions :many
+SELECT * FROM refresh_tokens
+WHERE user_id = $1
+AND revoked_at IS NULL
+AND expires_at > NOW()
+ORDER BY last_used_at DESC;
+
+-- name: RevokeSession :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE family_id = $1
+AND user_id = $2
+AND revoked_at IS NULL;
+
+-- name: RevokeAllSessions :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1
+AND revoked_at IS NULL;
\ No newline at end of file
@@ -0,0 +1,70 @@
+-- name: FanOutChirp :exec
+-- Copies a new chirp into the timelines of its author's followers, unless
+-- the author has too many followers for that.
+INSERT INTO timeline_entries (user_id, chirp_id, author_id, created_at)
+SELECT follows.follower_id, chirps.id, chirps.user_id, chirps.created_at
+FROM chirps
+JOIN users ON users.id = chirps.user_id
+JOIN follows ON follows.followee_id = chirps.user_id
+WHERE chirps.id = $1 AND NOT users.fanout_on_read
+ON CONFLICT DO NOTHING;
+
+-- name: BackfillTimeline :exec
+-- Copies the latest chirps of a newly followed user into a timeline.
+INSERT INTO timeline_entries (user_id, chirp_id, author_id, created_at)
+SELECT sqlc.arg(user_id)::uuid, recent.id, recent.user_id, recent.created_at
+FROM (
+    SELECT chirps.id, chirps.user_id, chirps.created_at
+    FROM chirps
+    JOIN users ON users.id = chirps.user_id
+    WHERE chirps.user_id = sqlc.arg(author_id)
+    AND chirps.deleted_at IS NULL
+    AND NOT users.fanout_on_read
+    ORDER BY chirps.created_at DESC, chirps.id DESC
+    LIMIT sqlc.arg(max_rows)
+) recent
+ON CONFLICT DO NOTHING;
+
+-- name: RemoveAuthorFromTimeline :exec
+DELETE FROM timeline_entries WHERE user_id = $1 AND author_id = $2;
+
+-- name: ListTimeline :many
+-- A user's timeline, newest first: the chirps copied into it, merged with
+-- the user's own chirps and those of followed accounts that are read
+-- directly. Pass the created_at and id of the last chirp of a page to get
+-- the next one. Chirps hidden from the user are left out.
+SELECT * FROM chirps
+WHERE chirps.id IN (
+    (
+        SELECT timeline_entries.chirp_id
+        FROM timeline_entries
+        JOIN chirps ON chirps.id = timeline_entries.chirp_id
+        WHERE timeline_entries.user_id = sqlc.arg(user_id)
+        AND chirps.deleted_at IS NULL
+        AND NOT chirp_hidden_from(sqlc.arg(user_id), chirps.user_id, chirps.body, chirps.rechirp_of)
+        AND (sqlc.narg(before_created_at)::timestamptz IS NULL OR (timeline_entries.created_at, timeline_entries.chirp_id) < (sqlc.narg(before_created_at), sqlc.narg(before_id)::uuid))
+        ORDER BY timeline_entries.created_at DESC, timeline_entries.chirp_id DESC
+        LIMIT sqlc.arg(max_rows)
+    )
+    UNION
+    (
+        SELECT chirps.id
+        FROM chirps
+        WHERE (
+            chirps.user_id = sqlc.arg(user_id)
+            OR chirps.user_id IN (
+                SELECT follows.followee_id
+                FROM follows
+                JOIN users ON users.id = follows.followee_id
+                WHERE follows.follower_id = sqlc.arg(user_id) AND users.fanout_on_read
+            )
+        )
+        AND chirps.deleted_at IS NULL
+        AND NOT chirp_hidden_from(sqlc.arg(user_id), chirps.user_id, chirps.body, chirps.rechirp_of)
+        AND (sqlc.narg(before_created_at)::timestamptz IS NULL OR (chirps.created_at, chirps.id) < (sqlc.narg(before_created_at), sqlc.narg(before_id)::uuid))
+        ORDER BY chirps.created_at DESC, chirps.id DESC
+        LIMIT sqlc.arg(max_rows)
+    )
+)
+ORDER BY chirps.created_at DESC, chirps.id DESC
+LIMIT sqlc.arg(max_rows);
\ No newline at end of file
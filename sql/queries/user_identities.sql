@@ -0,0 +1,15 @@
+-- name: CreateUserIdentity :exec
+INSERT INTO user_identities (issuer, subject, user_id, email)
+VALUES ($1, $2, $3, $4);
+
+-- name: GetUserByIdentity :one
+SELECT users.* FROM users
+JOIN user_identities ON user_identities.user_id = users.id
+WHERE user_identities.issuer = $1
+AND user_identities.subject = $2;
+
+-- name: TouchUserIdentity :exec
+UPDATE user_identities
+SET email = $3, last_login_at = NOW()
+WHERE issuer = $1
+AND subject = $2;
\ No newline at end of file
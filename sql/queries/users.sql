@@ -1,7 +1,7 @@
 -- name: CreateUser :one
-INSERT INTO users (email, hashed_password)
+INSERT INTO users (email, hashed_password, handle)
 VALUES (
-    $1, $2
+    $1, $2, $3
 )
 RETURNING *;
 
@@ -9,24 +9,77 @@ RETURNING *;
 DELETE FROM users;
 
 
+-- name: GetUser :one
+SELECT * FROM users WHERE id = $1;
+
 -- name: GetUserByEmail :one
 SELECT * FROM users WHERE email = $1;
 
--- name: GetUserFromRefreshToken :one
-SELECT * FROM users
-JOIN refresh_tokens ON users.id = refresh_tokens.user_id
-WHERE refresh_tokens.token = $1
-AND refresh_tokens.expires_at > NOW()
-AND refresh_tokens.revoked_at IS NULL;
+-- name: GetUserByHandle :one
+SELECT * FROM users WHERE lower(handle) = lower(sqlc.arg(handle));
 
--- name: UpdateUser :one
+-- name: UpgradeUserToRed :one
 UPDATE users
-SET email = $2, hashed_password = $3
+SET is_red = TRUE
 WHERE id = $1
 RETURNING *;
 
--- name: UpgradeUserToRed :one
+-- name: UpdateUserPassword :one
 UPDATE users
-SET is_red = TRUE
+SET hashed_password = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: SetPendingEmail :one
+UPDATE users
+SET pending_email = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: VerifyUserEmail :one
+-- The token must be for the current or the pending address, so an old link
+-- cannot confirm an address the user has since replaced. Confirming the
+-- current address leaves a pending change in place.
+UPDATE users
+SET email = sqlc.arg(email),
+    pending_email = CASE WHEN pending_email = sqlc.arg(email) THEN NULL ELSE pending_email END,
+    email_verified_at = NOW(), updated_at = NOW()
+WHERE id = sqlc.arg(id)
+AND (email = sqlc.arg(email) OR pending_email = sqlc.arg(email))
+RETURNING *;
+
+-- name: SetTOTPSecret :one
+UPDATE users
+SET totp_secret = $2, updated_at = NOW()
+WHERE id = $1
+AND totp_enabled_at IS NULL
+RETURNING *;
+
+-- name: EnableTOTP :one
+UPDATE users
+SET totp_enabled_at = NOW(), totp_last_step = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: UpdateTOTPLastStep :execrows
+UPDATE users
+SET totp_last_step = $2
+WHERE id = $1
+AND totp_last_step < $2;
+
+-- name: DisableTOTP :exec
+UPDATE users
+SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0, updated_at = NOW()
+WHERE id = $1;
+
+-- name: SetUserRole :one
+UPDATE users
+SET role = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: UpdateUserProfile :one
+UPDATE users
+SET handle = $2, display_name = $3, bio = $4, avatar_url = $5, website = $6, updated_at = NOW()
 WHERE id = $1
 RETURNING *;
\ No newline at end of file
@@ -0,0 +1,12 @@
+-- +goose Up
+ALTER TABLE refresh_tokens RENAME COLUMN token TO token_hash;
+-- Existing rows still hold the raw token and are hashed by the server on
+-- startup, since only the server knows the pepper. New rows are hashed on insert.
+ALTER TABLE refresh_tokens ADD COLUMN hashed BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE refresh_tokens ALTER COLUMN hashed SET DEFAULT TRUE;
+
+-- +goose Down
+-- Hashes cannot be reversed; rolling back logs out every session.
+DELETE FROM refresh_tokens WHERE hashed;
+ALTER TABLE refresh_tokens DROP COLUMN hashed;
+ALTER TABLE refresh_tokens RENAME COLUMN token_hash TO token;
@@ -0,0 +1,18 @@
+-- +goose Up
+-- A session is one refresh token family: it starts at login and survives
+-- token rotation, so family_id doubles as the session ID.
+ALTER TABLE refresh_tokens ADD COLUMN user_agent TEXT NOT NULL DEFAULT '';
+ALTER TABLE refresh_tokens ADD COLUMN ip TEXT NOT NULL DEFAULT '';
+ALTER TABLE refresh_tokens ADD COLUMN session_started_at timestamp with time zone NOT NULL DEFAULT now();
+ALTER TABLE refresh_tokens ADD COLUMN last_used_at timestamp with time zone NOT NULL DEFAULT now();
+UPDATE refresh_tokens
+SET session_started_at = COALESCE(created_at, now()),
+    last_used_at = COALESCE(created_at, now());
+CREATE INDEX refresh_tokens_user_id_idx ON refresh_tokens (user_id);
+
+-- +goose Down
+DROP INDEX refresh_tokens_user_id_idx;
+ALTER TABLE refresh_tokens DROP COLUMN last_used_at;
+ALTER TABLE refresh_tokens DROP COLUMN session_started_at;
+ALTER TABLE refresh_tokens DROP COLUMN ip;
+ALTER TABLE refresh_tokens DROP COLUMN user_agent;
@@ -0,0 +1,11 @@
+-- +goose Up
+CREATE TABLE password_reset_tokens (
+    token_hash TEXT PRIMARY KEY,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at timestamp with time zone NOT NULL,
+    used_at timestamp with time zone
+);
+
+-- +goose Down
+DROP TABLE password_reset_tokens;
\ No newline at end of file
@@ -0,0 +1,18 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN email_verified_at timestamp with time zone;
+-- A changed email stays here until the new address is confirmed.
+ALTER TABLE users ADD COLUMN pending_email TEXT;
+
+CREATE TABLE email_verification_tokens (
+    token_hash TEXT PRIMARY KEY,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    expires_at timestamp with time zone NOT NULL,
+    used_at timestamp with time zone
+);
+
+-- +goose Down
+DROP TABLE email_verification_tokens;
+ALTER TABLE users DROP COLUMN pending_email;
+ALTER TABLE users DROP COLUMN email_verified_at;
\ No newline at end of file
@@ -0,0 +1,10 @@
+-- +goose Up
+CREATE TABLE login_attempts (
+    key TEXT PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failure_at timestamp with time zone NOT NULL
+);
+CREATE INDEX login_attempts_last_failure_at_idx ON login_attempts (last_failure_at);
+
+-- +goose Down
+DROP TABLE login_attempts;
\ No newline at end of file
@@ -0,0 +1,11 @@
+-- +goose Up
+-- Chirps are paged by (created_at, id), which needs created_at on every row.
+UPDATE chirps SET created_at = now() WHERE created_at IS NULL;
+ALTER TABLE chirps ALTER COLUMN created_at SET NOT NULL;
+CREATE INDEX chirps_created_at_id_idx ON chirps (created_at, id);
+CREATE INDEX chirps_user_id_created_at_id_idx ON chirps (user_id, created_at, id);
+
+-- +goose Down
+DROP INDEX chirps_user_id_created_at_id_idx;
+DROP INDEX chirps_created_at_id_idx;
+ALTER TABLE chirps ALTER COLUMN created_at DROP NOT NULL;
\ No newline at end of file
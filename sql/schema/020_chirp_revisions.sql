@@ -0,0 +1,13 @@
+-- +goose Up
+CREATE TABLE chirp_revisions (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    chirp_id uuid NOT NULL REFERENCES chirps(id) ON DELETE CASCADE,
+    body TEXT NOT NULL,
+    -- When this version was written and when an edit replaced it.
+    created_at timestamp with time zone NOT NULL,
+    replaced_at timestamp with time zone NOT NULL DEFAULT now()
+);
+CREATE INDEX chirp_revisions_chirp_id_idx ON chirp_revisions (chirp_id, replaced_at);
+
+-- +goose Down
+DROP TABLE chirp_revisions;
\ No newline at end of file
@@ -0,0 +1,39 @@
+-- +goose Up
+ALTER TABLE chirps
+    ADD COLUMN in_reply_to uuid REFERENCES chirps(id) ON DELETE SET NULL,
+    ADD COLUMN conversation_id uuid,
+    ADD COLUMN reply_count INTEGER NOT NULL DEFAULT 0,
+    -- Deleted chirps with replies stay as tombstones so threads hold together.
+    ADD COLUMN deleted_at timestamp with time zone;
+UPDATE chirps SET conversation_id = id;
+ALTER TABLE chirps ALTER COLUMN conversation_id SET NOT NULL;
+CREATE INDEX chirps_in_reply_to_idx ON chirps (in_reply_to, created_at, id);
+CREATE INDEX chirps_conversation_id_idx ON chirps (conversation_id);
+
+-- A chirp that is not a reply starts its own conversation. Its id is only
+-- known once the default has been applied, which is before this trigger.
+-- +goose StatementBegin
+CREATE FUNCTION chirps_set_conversation_id() RETURNS trigger AS $$
+BEGIN
+    IF NEW.conversation_id IS NULL THEN
+        NEW.conversation_id := NEW.id;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER chirps_set_conversation_id
+    BEFORE INSERT ON chirps
+    FOR EACH ROW EXECUTE FUNCTION chirps_set_conversation_id();
+
+-- +goose Down
+DROP TRIGGER chirps_set_conversation_id ON chirps;
+DROP FUNCTION chirps_set_conversation_id();
+DROP INDEX chirps_conversation_id_idx;
+DROP INDEX chirps_in_reply_to_idx;
+ALTER TABLE chirps
+    DROP COLUMN deleted_at,
+    DROP COLUMN reply_count,
+    DROP COLUMN conversation_id,
+    DROP COLUMN in_reply_to;
\ No newline at end of file
@@ -0,0 +1,15 @@
+-- +goose Up
+CREATE TABLE chirp_likes (
+    user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    chirp_id uuid NOT NULL REFERENCES chirps(id) ON DELETE CASCADE,
+    created_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, chirp_id)
+);
+CREATE INDEX chirp_likes_chirp_id_idx ON chirp_likes (chirp_id, created_at, user_id);
+CREATE INDEX chirp_likes_user_id_idx ON chirp_likes (user_id, created_at, chirp_id);
+
+ALTER TABLE chirps ADD COLUMN like_count INTEGER NOT NULL DEFAULT 0;
+
+-- +goose Down
+ALTER TABLE chirps DROP COLUMN like_count;
+DROP TABLE chirp_likes;
\ No newline at end of file
@@ -0,0 +1,20 @@
+-- +goose Up
+-- A rechirp is a chirp of its own, so it shows up in listings at the time
+-- it was posted. It has no text and points at the chirp it reposts, and
+-- goes away with it.
+ALTER TABLE chirps
+    ADD COLUMN rechirp_of uuid REFERENCES chirps(id) ON DELETE CASCADE,
+    ADD COLUMN quote_of uuid REFERENCES chirps(id) ON DELETE SET NULL,
+    ADD CONSTRAINT chirps_rechirp_is_bare CHECK (
+        rechirp_of IS NULL OR (body = '' AND in_reply_to IS NULL AND quote_of IS NULL)
+    );
+CREATE UNIQUE INDEX chirps_rechirp_of_idx ON chirps (rechirp_of, user_id) WHERE rechirp_of IS NOT NULL;
+CREATE INDEX chirps_quote_of_idx ON chirps (quote_of) WHERE quote_of IS NOT NULL;
+
+-- +goose Down
+DROP INDEX chirps_quote_of_idx;
+DROP INDEX chirps_rechirp_of_idx;
+ALTER TABLE chirps
+    DROP CONSTRAINT chirps_rechirp_is_bare,
+    DROP COLUMN quote_of,
+    DROP COLUMN rechirp_of;
\ No newline at end of file
@@ -0,0 +1,39 @@
+-- +goose Up
+CREATE TABLE follows (
+    follower_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    followee_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (follower_id, followee_id),
+    CHECK (follower_id <> followee_id)
+);
+CREATE INDEX follows_followee_id_idx ON follows (followee_id, created_at, follower_id);
+CREATE INDEX follows_follower_id_idx ON follows (follower_id, created_at, followee_id);
+
+-- fanout_on_read marks accounts with so many followers that their chirps
+-- are not copied into every follower's timeline; timelines read them
+-- directly instead. It is never cleared, so no chirp falls between the two.
+ALTER TABLE users
+    ADD COLUMN follower_count INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN following_count INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN fanout_on_read BOOLEAN NOT NULL DEFAULT false;
+
+-- The chirps of followed accounts, copied when they are posted.
+CREATE TABLE timeline_entries (
+    user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    chirp_id uuid NOT NULL REFERENCES chirps(id) ON DELETE CASCADE,
+    author_id uuid NOT NULL,
+    -- created_at is the chirp's, so timelines page like chirp listings.
+    created_at timestamp with time zone NOT NULL,
+    PRIMARY KEY (user_id, chirp_id)
+);
+CREATE INDEX timeline_entries_user_id_idx ON timeline_entries (user_id, created_at DESC, chirp_id DESC);
+CREATE INDEX timeline_entries_author_idx ON timeline_entries (user_id, author_id);
+CREATE INDEX timeline_entries_chirp_id_idx ON timeline_entries (chirp_id);
+
+-- +goose Down
+DROP TABLE timeline_entries;
+ALTER TABLE users
+    DROP COLUMN fanout_on_read,
+    DROP COLUMN following_count,
+    DROP COLUMN follower_count;
+DROP TABLE follows;
\ No newline at end of file
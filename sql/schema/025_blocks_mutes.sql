@@ -0,0 +1,75 @@
+-- +goose Up
+CREATE TABLE blocks (
+    blocker_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    blocked_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (blocker_id, blocked_id),
+    CHECK (blocker_id <> blocked_id)
+);
+CREATE INDEX blocks_blocked_id_idx ON blocks (blocked_id, blocker_id);
+
+CREATE TABLE mutes (
+    muter_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    muted_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (muter_id, muted_id),
+    CHECK (muter_id <> muted_id)
+);
+
+-- Words are stored lower case and only contain characters that are not
+-- special in regular expressions, so they can be matched as whole words.
+CREATE TABLE muted_words (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    word TEXT NOT NULL,
+    created_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, word)
+);
+
+-- +goose StatementBegin
+CREATE FUNCTION blocked_between(user_a uuid, user_b uuid) RETURNS boolean AS $$
+    SELECT EXISTS (
+        SELECT 1 FROM blocks
+        WHERE (blocker_id = user_a AND blocked_id = user_b)
+        OR (blocker_id = user_b AND blocked_id = user_a)
+    )
+$$ LANGUAGE sql STABLE;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE FUNCTION muted_for(viewer_id uuid, author_id uuid, chirp_body text) RETURNS boolean AS $$
+    SELECT EXISTS (
+        SELECT 1 FROM mutes WHERE muter_id = viewer_id AND muted_id = author_id
+    ) OR EXISTS (
+        SELECT 1 FROM muted_words
+        WHERE muted_words.user_id = viewer_id
+        AND chirp_body ~* ('(^|[^[:alnum:]_])' || muted_words.word || '($|[^[:alnum:]_])')
+    )
+$$ LANGUAGE sql STABLE;
+-- +goose StatementEnd
+
+-- chirp_hidden_from reports whether a chirp is kept out of what viewer_id
+-- reads: its author, or the author of the chirp it rechirps, has blocked
+-- or been blocked or muted by the viewer, or it has a word the viewer
+-- muted. Nothing is hidden from anonymous viewers.
+-- +goose StatementBegin
+CREATE FUNCTION chirp_hidden_from(viewer_id uuid, author_id uuid, chirp_body text, original_id uuid) RETURNS boolean AS $$
+    SELECT viewer_id IS NOT NULL AND (
+        blocked_between(viewer_id, author_id)
+        OR muted_for(viewer_id, author_id, chirp_body)
+        OR EXISTS (
+            SELECT 1 FROM chirps original
+            WHERE original.id = original_id
+            AND (blocked_between(viewer_id, original.user_id) OR muted_for(viewer_id, original.user_id, original.body))
+        )
+    )
+$$ LANGUAGE sql STABLE;
+-- +goose StatementEnd
+
+-- +goose Down
+DROP FUNCTION chirp_hidden_from(uuid, uuid, text, uuid);
+DROP FUNCTION muted_for(uuid, uuid, text);
+DROP FUNCTION blocked_between(uuid, uuid);
+DROP TABLE muted_words;
+DROP TABLE mutes;
+DROP TABLE blocks;
\ No newline at end of file
@@ -0,0 +1,20 @@
+-- +goose Up
+-- Handles are unique regardless of case but keep the case they were chosen
+-- in. Users who signed up before handles existed have none until they pick
+-- one.
+ALTER TABLE users
+    ADD COLUMN handle TEXT,
+    ADD COLUMN display_name TEXT NOT NULL DEFAULT '',
+    ADD COLUMN bio TEXT NOT NULL DEFAULT '',
+    ADD COLUMN avatar_url TEXT NOT NULL DEFAULT '',
+    ADD COLUMN website TEXT NOT NULL DEFAULT '';
+CREATE UNIQUE INDEX users_handle_key ON users (lower(handle));
+
+-- +goose Down
+DROP INDEX users_handle_key;
+ALTER TABLE users
+    DROP COLUMN handle,
+    DROP COLUMN display_name,
+    DROP COLUMN bio,
+    DROP COLUMN avatar_url,
+    DROP COLUMN website;
\ No newline at end of file
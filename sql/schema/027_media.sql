@@ -0,0 +1,27 @@
+-- +goose Up
+-- Images uploaded for chirps. Each is stored in two sizes; the keys name
+-- the blobs in the blob store.
+CREATE TABLE media (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    content_type TEXT NOT NULL,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    blob_key TEXT NOT NULL,
+    thumbnail_key TEXT NOT NULL
+);
+CREATE INDEX media_user_id_idx ON media (user_id);
+
+CREATE TABLE chirp_attachments (
+    chirp_id uuid NOT NULL REFERENCES chirps(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    media_id uuid NOT NULL REFERENCES media(id) ON DELETE CASCADE,
+    alt_text TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (chirp_id, position)
+);
+CREATE INDEX chirp_attachments_media_id_idx ON chirp_attachments (media_id);
+
+-- +goose Down
+DROP TABLE chirp_attachments;
+DROP TABLE media;
\ No newline at end of file
@@ -0,0 +1,15 @@
+-- +goose Up
+-- Chirps created and deleted, for GET /api/stream. The ids let clients
+-- resume where they left off; old events are pruned.
+CREATE TABLE chirp_events (
+    id BIGSERIAL PRIMARY KEY,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    type TEXT NOT NULL,
+    -- No foreign key on chirp_id: deletions name chirps that are gone.
+    chirp_id uuid NOT NULL,
+    user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX chirp_events_created_at_idx ON chirp_events (created_at);
+
+-- +goose Down
+DROP TABLE chirp_events;
\ No newline at end of file
@@ -0,0 +1,8 @@
+-- +goose Up
+-- Logins are counted as failures before the password is checked, so
+-- concurrent guesses see each other. Whether an attempt had to wait depends
+-- on the failure before it, which is kept here.
+ALTER TABLE login_attempts ADD COLUMN previous_failure_at timestamp with time zone;
+
+-- +goose Down
+ALTER TABLE login_attempts DROP COLUMN previous_failure_at;
\ No newline at end of file
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/eefret/chirpy/internal/auth"
+	"github.com/eefret/chirpy/internal/database"
+	"github.com/eefret/chirpy/internal/events"
+	"github.com/google/uuid"
+)
+
+const (
+	// chirpEventRetention is how long a client can be away and still catch
+	// up on every event it missed.
+	chirpEventRetention = 24 * time.Hour
+	// streamReplayPage is how many stored events are read at a time when a
+	// client catches up.
+	streamReplayPage = 500
+	// streamKeepAlive is how often an idle stream sends a comment, so
+	// proxies do not close it.
+	streamKeepAlive = 30 * time.Second
+)
+
+// pruneChirpEvents periodically drops events too old to resume from.
+func pruneChirpEvents(q *database.Queries, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		if err := q.PruneChirpEvents(context.Background(), now.Add(-chirpEventRetention)); err != nil {
+			fmt.Println("Error pruning chirp events:", err)
+		}
+	}
+}
+
+// createChirpEvents stores events for changes made in the transaction of q.
+// It takes a lock that is held until the transaction ends, so events get
+// their ids in the order they are committed and a stream that has read an
+// event has seen every event before it. Call it right before committing.
+func createChirpEvents(ctx context.Context, q *database.Queries, params ...database.CreateChirpEventParams) ([]database.ChirpEvent, error) {
+	if err := q.LockChirpEvents(ctx); err != nil {
+		return nil, err
+	}
+	created := make([]database.ChirpEvent, 0, len(params))
+	for _, p := range params {
+		e, err := q.CreateChirpEvent(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		created = append(created, e)
+	}
+	return created, nil
+}
+
+// publishChirpEvents sends events stored with createChirpEvents to the
+// clients of GET /api/stream. Call it once the events are committed.
+func (cfg *apiConfig) publishChirpEvents(r *http.Request, stored []database.ChirpEvent) {
+	for _, e := range stored {
+		err := cfg.events.Publish(r.Context(), events.Event{
+			ID:        e.ID,
+			CreatedAt: e.CreatedAt,
+			Type:      e.Type,
+			ChirpID:   e.ChirpID,
+			UserID:    e.UserID,
+		})
+		if err != nil {
+			// Connected clients miss the event, but clients that reconnect
+			// still get it from the database.
+			fmt.Println("Error publishing chirp event:", err)
+		}
+	}
+}
+
+// recordChirpEvent stores and publishes an event for a change made outside
+// a transaction.
+func (cfg *apiConfig) recordChirpEvent(r *http.Request, eventType string, chirp database.Chirp) {
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		fmt.Println("Error recording chirp event:", err)
+		return
+	}
+	defer tx.Rollback()
+
+	stored, err := createChirpEvents(r.Context(), cfg.DB.WithTx(tx), database.CreateChirpEventParams{
+		Type:    eventType,
+		ChirpID: chirp.ID,
+		UserID:  chirp.UserID,
+	})
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		fmt.Println("Error recording chirp event:", err)
+		return
+	}
+	cfg.publishChirpEvents(r, stored)
+}
+
+// DeletedChirp is the data of a chirp.deleted event.
+type DeletedChirp struct {
+	ID     uuid.UUID `json:"id"`
+	UserID uuid.UUID `json:"user_id"`
+}
+
+// chirpStream is one client's GET /api/stream.
+type chirpStream struct {
+	cfg *apiConfig
+	w   http.ResponseWriter
+	r   *http.Request
+	rc  *http.ResponseController
+	// viewer is the logged in user, whose blocks and mutes apply, or
+	// uuid.Nil.
+	viewer uuid.UUID
+	// authors limits the stream to chirps by these users, unless nil.
+	authors map[uuid.UUID]bool
+	// caughtUpTo is the last stored event the stream has read. Events get
+	// their ids in commit order, so every event up to it has been sent or
+	// filtered out.
+	caughtUpTo int64
+}
+
+// send writes e to the client if it passes the stream's filters.
+func (s *chirpStream) send(e events.Event) error {
+	if s.authors != nil && !s.authors[e.UserID] {
+		return nil
+	}
+
+	var data any
+	switch e.Type {
+	case events.ChirpCreated:
+		chirps, err := s.cfg.DB.GetChirpsByIDs(s.r.Context(), database.GetChirpsByIDsParams{
+			Ids:      []uuid.UUID{e.ChirpID},
+			ViewerID: nullViewer(s.viewer),
+		})
+		if err != nil {
+			return err
+		}
+		// The chirp is hidden from the viewer, or already gone again.
+		if len(chirps) == 0 || chirps[0].DeletedAt.Valid {
+			return nil
+		}
+		chirp := chirpFromDB(chirps[0])
+		s.cfg.fillChirps(s.r, &chirp)
+		data = chirp
+	case events.ChirpDeleted:
+		data = DeletedChirp{ID: e.ChirpID, UserID: e.UserID}
+	default:
+		return nil
+	}
+
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, payload); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+// catchUp sends the stored events after the last one the stream read.
+func (s *chirpStream) catchUp() error {
+	for {
+		stored, err := s.cfg.DB.ListChirpEventsAfter(s.r.Context(), database.ListChirpEventsAfterParams{
+			AfterID: s.caughtUpTo,
+			MaxRows: streamReplayPage,
+		})
+		if err != nil {
+			return err
+		}
+		for _, e := range stored {
+			err := s.send(events.Event{
+				ID:        e.ID,
+				CreatedAt: e.CreatedAt,
+				Type:      e.Type,
+				ChirpID:   e.ChirpID,
+				UserID:    e.UserID,
+			})
+			if err != nil {
+				return err
+			}
+			s.caughtUpTo = e.ID
+		}
+		if len(stored) < streamReplayPage {
+			return nil
+		}
+	}
+}
+
+// handleStream pushes chirps as they are created and deleted, as
+// Server-Sent Events.
+func (cfg *apiConfig) handleStream(w http.ResponseWriter, r *http.Request) {
+	s := &chirpStream{cfg: cfg, w: w, r: r, rc: http.NewResponseController(w)}
+
+	query := r.URL.Query()
+	authorID := query.Get("author_id")
+	timeline := query.Get("timeline") == "true"
+	if authorID != "" && timeline {
+		respondWithError(w, http.StatusBadRequest, "author_id and timeline cannot be combined")
+		return
+	}
+
+	if timeline {
+		caller, ok := cfg.authenticate(w, r, auth.ScopeChirpsRead)
+		if !ok {
+			return
+		}
+		s.viewer = caller.UserID
+		// Follows made after connecting apply from the next connection.
+		followed, err := cfg.DB.ListFollowedIDs(r.Context(), caller.UserID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Could not open stream")
+			return
+		}
+		s.authors = map[uuid.UUID]bool{caller.UserID: true}
+		for _, id := range followed {
+			s.authors[id] = true
+		}
+	} else {
+		s.viewer = cfg.viewer(r)
+	}
+
+	if authorID != "" {
+		id, err := uuid.Parse(authorID)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid author ID")
+			return
+		}
+		s.authors = map[uuid.UUID]bool{id: true}
+	}
+
+	// Browsers send Last-Event-ID when they reconnect; last_event_id lets
+	// a client resume on its first connection too.
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = query.Get("last_event_id")
+	}
+	resume := lastEventID != ""
+	if resume {
+		id, err := strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil || id < 0 {
+			respondWithError(w, http.StatusBadRequest, "Invalid Last-Event-ID")
+			return
+		}
+		s.caughtUpTo = id
+	}
+
+	// Subscribing before reading where to start means no event falls in
+	// between.
+	sub := cfg.events.Subscribe()
+	defer sub.Close()
+
+	if !resume {
+		last, err := cfg.DB.GetLastChirpEventID(r.Context())
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Could not open stream")
+			return
+		}
+		s.caughtUpTo = last
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Stops nginx from buffering the stream.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := s.rc.Flush(); err != nil {
+		return
+	}
+
+	if resume {
+		if err := s.catchUp(); err != nil {
+			fmt.Println("Error catching up chirp stream:", err)
+			return
+		}
+	}
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-sub.C:
+			if !ok {
+				// The client fell behind or events may have been lost.
+				// Ending the stream makes it reconnect with Last-Event-ID
+				// and catch up.
+				return
+			}
+			// Events can be published out of order, so a live event only
+			// says there is something new; reading from the database sends
+			// everything up to it in order, once.
+			if e.ID <= s.caughtUpTo {
+				continue
+			}
+			if err := s.catchUp(); err != nil {
+				fmt.Println("Error sending chirp events:", err)
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			if err := s.rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
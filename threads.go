@@ -0,0 +1,137 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/eefret/chirpy/internal/database"
+	"github.com/eefret/chirpy/internal/pagination"
+	"github.com/google/uuid"
+)
+
+const (
+	// defaultThreadDepth and maxThreadDepth bound how many levels of
+	// replies a thread includes below the chirp.
+	defaultThreadDepth = 3
+	maxThreadDepth     = 5
+	// maxThreadChildren is how many replies are included per chirp below
+	// the first level. Clients open the thread of a reply to see the rest.
+	maxThreadChildren = 10
+)
+
+// ThreadReply is a reply in a thread, with the replies to it.
+type ThreadReply struct {
+	Chirp
+	Replies []ThreadReply `json:"replies"`
+}
+
+// handleChirpThread returns a chirp with the chirps it replies to, root
+// first, and a page of its replies as a tree. The limit and cursor page
+// through the direct replies, oldest first.
+func (cfg *apiConfig) handleChirpThread(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	page, ok := parsePageRequest(w, r)
+	if !ok {
+		return
+	}
+
+	depth := defaultThreadDepth
+	if v := r.URL.Query().Get("depth"); v != "" {
+		depth, err = strconv.Atoi(v)
+		if err != nil || depth < 1 || depth > maxThreadDepth {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("depth must be between 1 and %d", maxThreadDepth))
+			return
+		}
+	}
+
+	viewer := cfg.viewer(r)
+	chirp, err := cfg.DB.GetChirp(r.Context(), chirpID)
+	if err != nil || cfg.chirpBlocked(r.Context(), viewer, chirp) {
+		respondWithError(w, http.StatusNotFound, "Chirp not found")
+		return
+	}
+
+	ancestors, err := cfg.DB.ListChirpAncestors(r.Context(), database.ListChirpAncestorsParams{
+		ID:       chirp.ID,
+		ViewerID: nullViewer(viewer),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not load thread")
+		return
+	}
+
+	replies, err := cfg.DB.ListReplyTree(r.Context(), database.ListReplyTreeParams{
+		ParentID:       chirp.ID,
+		AfterCreatedAt: sql.NullTime{Time: page.Cursor.CreatedAt, Valid: page.HasCursor},
+		AfterID:        uuid.NullUUID{UUID: page.Cursor.ID, Valid: page.HasCursor},
+		ViewerID:       nullViewer(viewer),
+		MaxRows:        page.maxRows(),
+		MaxChildren:    maxThreadChildren,
+		MaxDepth:       int32(depth),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not load thread")
+		return
+	}
+
+	type ThreadResponse struct {
+		Ancestors  []Chirp       `json:"ancestors"`
+		Chirp      Chirp         `json:"chirp"`
+		Replies    []ThreadReply `json:"replies"`
+		NextCursor *string       `json:"next_cursor"`
+	}
+
+	response := ThreadResponse{
+		Ancestors: []Chirp{},
+		Chirp:     chirpFromDB(chirp),
+		Replies:   buildReplyTree(chirp.ID, replies),
+	}
+	for _, ancestor := range ancestors {
+		response.Ancestors = append(response.Ancestors, chirpFromDB(ancestor))
+	}
+	response.Replies, response.NextCursor = nextPage(cfg, w, r, page, response.Replies, func(last ThreadReply) string {
+		return pagination.Cursor{CreatedAt: last.CreatedAt, ID: last.ID}.Encode()
+	})
+
+	chirps := append([]*Chirp{&response.Chirp}, chirpPtrs(response.Ancestors)...)
+	cfg.fillChirps(r, append(chirps, replyTreeChirps(response.Replies)...)...)
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// buildReplyTree nests replies under the chirps they reply to, starting
+// from the replies to root. Replies keep the order they are given in.
+func buildReplyTree(root uuid.UUID, replies []database.Chirp) []ThreadReply {
+	children := map[uuid.UUID][]database.Chirp{}
+	for _, reply := range replies {
+		children[reply.InReplyTo.UUID] = append(children[reply.InReplyTo.UUID], reply)
+	}
+
+	var build func(parent uuid.UUID) []ThreadReply
+	build = func(parent uuid.UUID) []ThreadReply {
+		nodes := []ThreadReply{}
+		for _, c := range children[parent] {
+			nodes = append(nodes, ThreadReply{Chirp: chirpFromDB(c), Replies: build(c.ID)})
+		}
+		return nodes
+	}
+	return build(root)
+}
+
+// replyTreeChirps returns pointers to every chirp in a reply tree, for
+// fillChirps.
+func replyTreeChirps(replies []ThreadReply) []*Chirp {
+	var chirps []*Chirp
+	for i := range replies {
+		chirps = append(chirps, &replies[i].Chirp)
+		chirps = append(chirps, replyTreeChirps(replies[i].Replies)...)
+	}
+	return chirps
+}
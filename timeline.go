@@ -0,0 +1,60 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/eefret/chirpy/internal/auth"
+	"github.com/eefret/chirpy/internal/database"
+	"github.com/eefret/chirpy/internal/pagination"
+	"github.com/google/uuid"
+)
+
+// defaultFanoutLimit is the default for TIMELINE_FANOUT_LIMIT.
+//
+// Chirps are copied into the timelines of the author's followers when they
+// are posted, so reading a timeline is a single index scan. Copying is
+// too slow for accounts with very many followers; once an account reaches
+// the limit its chirps are read into its followers' timelines instead.
+const defaultFanoutLimit = 10000
+
+// handleTimeline returns the chirps of the accounts the caller follows,
+// along with the caller's own, newest first.
+func (cfg *apiConfig) handleTimeline(w http.ResponseWriter, r *http.Request) {
+	caller, ok := cfg.authenticate(w, r, auth.ScopeChirpsRead)
+	if !ok {
+		return
+	}
+
+	page, ok := parsePageRequest(w, r)
+	if !ok {
+		return
+	}
+
+	chirps, err := cfg.DB.ListTimeline(r.Context(), database.ListTimelineParams{
+		UserID:          caller.UserID,
+		BeforeCreatedAt: sql.NullTime{Time: page.Cursor.CreatedAt, Valid: page.HasCursor},
+		BeforeID:        uuid.NullUUID{UUID: page.Cursor.ID, Valid: page.HasCursor},
+		MaxRows:         page.maxRows(),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not load timeline")
+		return
+	}
+
+	type ChirpPage struct {
+		Chirps     []Chirp `json:"chirps"`
+		NextCursor *string `json:"next_cursor"`
+	}
+
+	response := ChirpPage{Chirps: []Chirp{}}
+	chirps, response.NextCursor = nextPage(cfg, w, r, page, chirps, func(last database.Chirp) string {
+		return pagination.Cursor{CreatedAt: last.CreatedAt, ID: last.ID}.Encode()
+	})
+	for _, chirp := range chirps {
+		response.Chirps = append(response.Chirps, chirpFromDB(chirp))
+	}
+	cfg.fillChirps(r, chirpPtrs(response.Chirps)...)
+
+	respondWithJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/eefret/chirpy/internal/auth"
+	"github.com/eefret/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// PersonalAccessToken is a named long-lived token for scripts and bots. The
+// token itself is only returned when it is created.
+type PersonalAccessToken struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Token      string     `json:"token,omitempty"`
+}
+
+func personalAccessTokenFromDB(t database.PersonalAccessToken) PersonalAccessToken {
+	pat := PersonalAccessToken{
+		ID:        t.ID,
+		Name:      t.Name,
+		Scopes:    t.Scopes,
+		CreatedAt: t.CreatedAt,
+	}
+	if t.ExpiresAt.Valid {
+		pat.ExpiresAt = &t.ExpiresAt.Time
+	}
+	if t.LastUsedAt.Valid {
+		pat.LastUsedAt = &t.LastUsedAt.Time
+	}
+	return pat
+}
+
+// handleCreateToken creates a personal access token. Tokens can only be
+// managed with a session, so a leaked token cannot mint more tokens.
+func (cfg *apiConfig) handleCreateToken(w http.ResponseWriter, r *http.Request) {
+	caller, ok := cfg.authenticate(w, r, "")
+	if !ok {
+		return
+	}
+
+	type CreateTokenRequest struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	request := CreateTokenRequest{}
+
+	err := decoder.Decode(&request)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if request.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "Name is required")
+		return
+	}
+
+	if len(request.Scopes) == 0 {
+		respondWithError(w, http.StatusBadRequest, "At least one scope is required")
+		return
+	}
+	for _, scope := range request.Scopes {
+		if !auth.ValidScope(scope) {
+			respondWithError(w, http.StatusBadRequest, "Unknown scope: "+scope)
+			return
+		}
+	}
+
+	if request.ExpiresInDays < 0 {
+		respondWithError(w, http.StatusBadRequest, "expires_in_days must not be negative")
+		return
+	}
+	var expiresAt sql.NullTime
+	if request.ExpiresInDays > 0 {
+		expiresAt = sql.NullTime{Time: time.Now().AddDate(0, 0, request.ExpiresInDays), Valid: true}
+	}
+
+	token, err := auth.MakePersonalAccessToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not generate token")
+		return
+	}
+
+	t, err := cfg.DB.CreatePersonalAccessToken(r.Context(), database.CreatePersonalAccessTokenParams{
+		UserID:    caller.UserID,
+		Name:      request.Name,
+		TokenHash: auth.HashToken(token, cfg.tokenPepper),
+		Scopes:    request.Scopes,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not save token")
+		return
+	}
+
+	cfg.audit(r, auditEvent{
+		Actor:      caller.UserID,
+		Action:     auditTokenCreated,
+		TargetType: "token",
+		TargetID:   t.ID.String(),
+		Metadata:   map[string]any{"name": t.Name, "scopes": t.Scopes},
+	})
+
+	pat := personalAccessTokenFromDB(t)
+	pat.Token = token
+
+	respondWithJSON(w, http.StatusCreated, pat)
+}
+
+func (cfg *apiConfig) handleListTokens(w http.ResponseWriter, r *http.Request) {
+	caller, ok := cfg.authenticate(w, r, "")
+	if !ok {
+		return
+	}
+
+	tokens, err := cfg.DB.ListPersonalAccessTokens(r.Context(), caller.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not list tokens")
+		return
+	}
+
+	response := []PersonalAccessToken{}
+	for _, t := range tokens {
+		response = append(response, personalAccessTokenFromDB(t))
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cfg *apiConfig) handleDeleteToken(w http.ResponseWriter, r *http.Request) {
+	caller, ok := cfg.authenticate(w, r, "")
+	if !ok {
+		return
+	}
+
+	tokenID, err := uuid.Parse(r.PathValue("tokenID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid token ID")
+		return
+	}
+
+	revoked, err := cfg.DB.RevokePersonalAccessToken(r.Context(), database.RevokePersonalAccessTokenParams{
+		ID:     tokenID,
+		UserID: caller.UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not revoke token")
+		return
+	}
+	if revoked == 0 {
+		respondWithError(w, http.StatusNotFound, "Token not found")
+		return
+	}
+
+	cfg.audit(r, auditEvent{
+		Actor:      caller.UserID,
+		Action:     auditTokenRevoked,
+		TargetType: "token",
+		TargetID:   tokenID.String(),
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
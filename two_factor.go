@@ -0,0 +1,344 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/eefret/chirpy/internal/auth"
+	"github.com/eefret/chirpy/internal/database"
+)
+
+const (
+	mfaTokenTTL       = 5 * time.Minute
+	recoveryCodeCount = 10
+	totpIssuer        = "Chirpy"
+)
+
+// respondWithMFAChallenge answers a login with a correct password for a user
+// enrolled in two-factor authentication. The challenge token is exchanged
+// for real tokens at /api/login/2fa.
+func (cfg *apiConfig) respondWithMFAChallenge(w http.ResponseWriter, u database.User) {
+	mfaToken, err := auth.MakeMFAToken(u.ID, cfg.jwtKeys, mfaTokenTTL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not get MFA token")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, struct {
+		MFARequired bool   `json:"mfa_required"`
+		MFAToken    string `json:"mfa_token"`
+	}{
+		MFARequired: true,
+		MFAToken:    mfaToken,
+	})
+}
+
+// checkSecondFactor accepts either a TOTP code or an unused recovery code.
+// Both are consumed, so neither can be replayed.
+func (cfg *apiConfig) checkSecondFactor(r *http.Request, q *database.Queries, u database.User, code, recoveryCode string) bool {
+	if recoveryCode != "" {
+		used, err := q.UseRecoveryCode(r.Context(), database.UseRecoveryCodeParams{
+			UserID:   u.ID,
+			CodeHash: auth.HashToken(auth.NormalizeRecoveryCode(recoveryCode), cfg.tokenPepper),
+		})
+		return err == nil && used == 1
+	}
+
+	secret, err := auth.OpenSecret(u.TotpSecret.String, cfg.tokenPepper)
+	if err != nil {
+		return false
+	}
+
+	step, ok := auth.ValidateTOTP(secret, code, time.Now(), u.TotpLastStep)
+	if !ok {
+		return false
+	}
+
+	// Guards against two requests racing with the same code.
+	updated, err := q.UpdateTOTPLastStep(r.Context(), database.UpdateTOTPLastStepParams{
+		ID:           u.ID,
+		TotpLastStep: step,
+	})
+	return err == nil && updated == 1
+}
+
+func (cfg *apiConfig) handleLoginMFA(w http.ResponseWriter, r *http.Request) {
+	type LoginMFARequest struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+		OAuthConsent bool   `json:"oauth_consent"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	request := LoginMFARequest{}
+
+	err := decoder.Decode(&request)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	id, err := auth.ValidateMFAToken(request.MFAToken, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid MFA token")
+		return
+	}
+
+	u, err := cfg.DB.GetUser(r.Context(), id)
+	if err != nil || !u.TotpEnabledAt.Valid {
+		respondWithError(w, http.StatusUnauthorized, "Invalid MFA token")
+		return
+	}
+
+	// Codes are counted against the same limit as passwords, so a stolen
+	// password does not allow unlimited guessing of the six digit code.
+	if !cfg.reserveLoginAttempt(w, r, u.Email) {
+		return
+	}
+
+	if !cfg.checkSecondFactor(r, cfg.DB, u, request.Code, request.RecoveryCode) {
+		cfg.recordLoginFailure(r, u.Email, u.ID, "wrong_code")
+		respondWithError(w, http.StatusUnauthorized, "Incorrect code")
+		return
+	}
+
+	method := "totp"
+	if request.RecoveryCode != "" {
+		method = "recovery_code"
+	}
+
+	cfg.clearLoginFailures(r, u.Email)
+	if request.OAuthConsent {
+		cfg.respondWithConsentToken(w, r, u, method)
+		return
+	}
+	cfg.completeLogin(w, r, u, method)
+}
+
+// handleSetupTOTP generates a new secret for the user. It is not used for
+// logins until a code from it is confirmed at /api/2fa/verify.
+func (cfg *apiConfig) handleSetupTOTP(w http.ResponseWriter, r *http.Request) {
+	caller, ok := cfg.authenticate(w, r, "")
+	if !ok {
+		return
+	}
+	id := caller.UserID
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not generate secret")
+		return
+	}
+
+	sealed, err := auth.SealSecret(secret, cfg.tokenPepper)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not generate secret")
+		return
+	}
+
+	u, err := cfg.DB.SetTOTPSecret(r.Context(), database.SetTOTPSecretParams{
+		ID:         id,
+		TotpSecret: sql.NullString{String: sealed, Valid: true},
+	})
+	if err != nil {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, struct {
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`
+	}{
+		Secret:     secret,
+		OTPAuthURI: auth.TOTPURI(totpIssuer, u.Email, secret),
+	})
+}
+
+// handleVerifyTOTP turns on two-factor authentication once the user proves
+// their authenticator works, and hands out recovery codes. The codes are
+// only ever shown here.
+func (cfg *apiConfig) handleVerifyTOTP(w http.ResponseWriter, r *http.Request) {
+	caller, ok := cfg.authenticate(w, r, "")
+	if !ok {
+		return
+	}
+	id := caller.UserID
+
+	type VerifyTOTPRequest struct {
+		Code string `json:"code"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	request := VerifyTOTPRequest{}
+
+	err := decoder.Decode(&request)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	u, err := cfg.DB.GetUser(r.Context(), id)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	if u.TotpEnabledAt.Valid {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+	if !u.TotpSecret.Valid {
+		respondWithError(w, http.StatusBadRequest, "Call /api/2fa/setup first")
+		return
+	}
+
+	secret, err := auth.OpenSecret(u.TotpSecret.String, cfg.tokenPepper)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not read secret")
+		return
+	}
+
+	step, ok := auth.ValidateTOTP(secret, request.Code, time.Now(), u.TotpLastStep)
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Incorrect code")
+		return
+	}
+
+	codes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not generate recovery codes")
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not enable two-factor authentication")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	if err := qtx.DeleteRecoveryCodes(r.Context(), id); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not enable two-factor authentication")
+		return
+	}
+	for _, code := range codes {
+		err := qtx.CreateRecoveryCode(r.Context(), database.CreateRecoveryCodeParams{
+			UserID:   id,
+			CodeHash: auth.HashToken(auth.NormalizeRecoveryCode(code), cfg.tokenPepper),
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Could not enable two-factor authentication")
+			return
+		}
+	}
+
+	_, err = qtx.EnableTOTP(r.Context(), database.EnableTOTPParams{
+		ID:           id,
+		TotpLastStep: step,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not enable two-factor authentication")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not enable two-factor authentication")
+		return
+	}
+
+	cfg.audit(r, auditEvent{
+		Actor:      id,
+		Action:     auditTwoFactorEnabled,
+		TargetType: "user",
+		TargetID:   id.String(),
+	})
+
+	respondWithJSON(w, http.StatusOK, struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{
+		RecoveryCodes: codes,
+	})
+}
+
+// handleDisableTOTP turns two-factor authentication off. It asks for a
+// current code so a stolen access token alone cannot remove the second factor.
+func (cfg *apiConfig) handleDisableTOTP(w http.ResponseWriter, r *http.Request) {
+	caller, ok := cfg.authenticate(w, r, "")
+	if !ok {
+		return
+	}
+	id := caller.UserID
+
+	type DisableTOTPRequest struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	request := DisableTOTPRequest{}
+
+	err := decoder.Decode(&request)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	u, err := cfg.DB.GetUser(r.Context(), id)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	if !u.TotpEnabledAt.Valid {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is not enabled")
+		return
+	}
+
+	// Codes are counted against the login limits too, or a stolen access
+	// token would allow guessing them without end.
+	if !cfg.reserveLoginAttempt(w, r, u.Email) {
+		return
+	}
+
+	if !cfg.checkSecondFactor(r, cfg.DB, u, request.Code, request.RecoveryCode) {
+		cfg.recordLoginFailure(r, u.Email, u.ID, "wrong_code")
+		respondWithError(w, http.StatusUnauthorized, "Incorrect code")
+		return
+	}
+	cfg.clearLoginFailures(r, u.Email)
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not disable two-factor authentication")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	if err := qtx.DeleteRecoveryCodes(r.Context(), id); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not disable two-factor authentication")
+		return
+	}
+	if err := qtx.DisableTOTP(r.Context(), id); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not disable two-factor authentication")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not disable two-factor authentication")
+		return
+	}
+
+	cfg.audit(r, auditEvent{
+		Actor:      id,
+		Action:     auditTwoFactorDisabled,
+		TargetType: "user",
+		TargetID:   id.String(),
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}